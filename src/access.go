@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// accessThrottle is the minimum time between two lastAccessedAt writes for the same snippet
+const accessThrottle = time.Minute
+
+/*
+touchSnippet records that a snippet was just read.
+
+	The view is counted in viewCounts, which writes the counts in batches. To avoid a write
+	on every read the update is skipped when the loaded document was touched less than
+	accessThrottle ago. The same condition is repeated in the filter, so concurrent
+	reads racing past the first check still write at most once. It runs in the background
+	with its own timeout, a failure is only logged and never affects the read. Read-only mode
+	promises no writes, the access time isn't recorded then.
+*/
+func touchSnippet(s CodeSnippetModel) {
+	// not counted while read-only either, see viewCounter
+	viewCounts.add(s.ID)
+	if readOnly.Load() {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-accessThrottle)
+	if s.LastAccessedAt != nil && s.LastAccessedAt.After(cutoff) {
+		return
+	}
+	snippetCache.touched(s.ID, now)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		filter := bson.M{
+			"_id": s.ID,
+			"$or": bson.A{
+				bson.M{"lastAccessedAt": bson.M{"$exists": false}},
+				bson.M{"lastAccessedAt": bson.M{"$lt": cutoff}},
+			},
+		}
+		update := bson.M{"$set": bson.M{"lastAccessedAt": now}}
+		if _, err := db.Collection(collectionName).UpdateOne(ctx, filter, update); err != nil {
+			log.Printf("failed to record access for snippet %s: %s\n", s.ID.Hex(), err)
+		}
+	}()
+}
+
+/*
+getStaleSnippets lists snippets nobody has read in the last ?days= days (default 90), paginated.
+
+	Snippets that were never read since access tracking exists count as stale when they
+	were created before the cutoff. The least recently used snippets come first.
+*/
+func getStaleSnippets(w http.ResponseWriter, r *http.Request) {
+	days := 90
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "days must be a positive integer")
+			return
+		}
+		days = n
+	}
+
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"lastAccessedAt": bson.M{"$lt": cutoff}},
+		bson.M{"lastAccessedAt": bson.M{"$exists": false}, "createAt": bson.M{"$lt": cutoff}},
+	}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "lastAccessedAt", Value: 1}, {Key: "createAt", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+
+	cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
+	if err != nil {
+		log.Printf("failed to fetch stale snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+
+	snippets := []CodeSnippetModel{}
+	if err := cursor.All(r.Context(), &snippets); err != nil {
+		log.Printf("failed to fetch stale snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+
+	snippetsList := []CodeSnippet{}
+	for _, s := range snippets {
+		snippetsList = append(snippetsList, snippetResponse(r, s))
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       snippetsList,
+		"pagination": page,
+	})
+}
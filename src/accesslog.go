@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+/*
+accessLogger logs one key=value line per request, replacing chi's middleware.Logger.
+
+	bytes is the size of the response body as written by the handler (after any envelope or
+	JSONP wrapping, before compression by a proxy), so oversized payloads stand out per route.
+	route is the chi pattern (e.g. /code-snippets/id/{codeid}), which groups requests for the
+	same endpoint together regardless of the ids in the path.
+*/
+func accessLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+
+		log.Printf("method=%s path=%q route=%q status=%d bytes=%d duration=%s remote=%s\n",
+			r.Method, r.URL.RequestURI(), route, status, ww.BytesWritten(), time.Since(start).Round(time.Microsecond), r.RemoteAddr)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// adminHandlers returns the router for operator endpoints, every route requires the admin API key
+func adminHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(middleware.GetHead, optionsResponder(rg))
+	rg.Use(requireAPIKey)
+	rg.Use(requestTimeout(cfg.RequestTimeout))
+	rg.Group(func(r chi.Router) {
+		r.Get("/read-only", getReadOnly)
+		// the switch itself is the one write that has to work in read-only mode
+		r.Put("/read-only", putReadOnly)
+		r.Get("/integrity", checkIntegrity)
+	})
+	rg.Group(func(r chi.Router) {
+		r.Use(readOnlyGuard)
+		r.Delete("/reset", resetCollection)
+		r.Post("/seed", seedCollection)
+	})
+	return rg
+}
+
+func getReadOnly(w http.ResponseWriter, r *http.Request) {
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"read_only": readOnly.Load(),
+	})
+}
+
+// putReadOnly switches read-only mode on or off, body: {"enabled": true}
+func putReadOnly(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		writeDecodeError(w, r, err, `the request body must be {"enabled": true|false}`)
+		return
+	}
+
+	setReadOnly(*body.Enabled)
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"read_only": readOnly.Load(),
+	})
+}
+
+// resetCollection deletes every snippet in the collection, meant for tearing down integration tests
+func resetCollection(w http.ResponseWriter, r *http.Request) {
+	// must be explicitly turned on (ALLOW_ADMIN_RESET=true), never set it in production
+	if !cfg.AllowAdminReset {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "reset is disabled, set ALLOW_ADMIN_RESET=true to enable it")
+		return
+	}
+
+	result, err := db.Collection(collectionName).DeleteMany(r.Context(), bson.M{})
+	snippetCache.purge()
+	if err != nil {
+		log.Printf("failed to reset collection: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to reset the collection")
+		return
+	}
+
+	log.Printf("admin reset removed %d snippets\n", result.DeletedCount)
+	deleteSnippetDependents(r.Context(), bson.M{})
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Collection reset successfully",
+		"deleted": result.DeletedCount,
+	})
+}
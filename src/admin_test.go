@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminWritesInReadOnlyMode checks that read-only mode stops the admin writes except the switch itself
+func TestAdminWritesInReadOnlyMode(t *testing.T) {
+	oldKey := cfg.AdminAPIKey
+	cfg.AdminAPIKey = "test-key"
+	setReadOnly(true)
+	t.Cleanup(func() {
+		cfg.AdminAPIKey = oldKey
+		setReadOnly(false)
+	})
+	router := adminHandlers()
+
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+	}{
+		{http.MethodDelete, "/reset", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/seed", "", http.StatusServiceUnavailable},
+		{http.MethodPut, "/read-only", `{"enabled": true}`, http.StatusOK},
+		{http.MethodGet, "/read-only", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", "test-key")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
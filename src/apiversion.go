@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+request schema versions a client can pin with Accept: application/vnd.snippets.v<N>+json.
+
+	v1 is the original schema, the name is sent as "snippetname".
+	v2 (the latest, used when no version is asked for) sends it as "snippet_name".
+	Only the request body differs between versions, responses are the same.
+*/
+const (
+	apiVersion1      = 1
+	apiVersion2      = 2
+	apiVersionLatest = apiVersion2
+)
+
+// vendorMediaType matches the versioned media type and captures the version number
+var vendorMediaType = regexp.MustCompile(`^application/vnd\.snippets\.v(\d+)\+json$`)
+
+// codeSnippetV1 is the request body of schema v1
+type codeSnippetV1 struct {
+	SnippetName string     `json:"snippetname"`
+	Code        string     `json:"code"`
+	Language    string     `json:"language"`
+	Tags        []string   `json:"tags"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	MaxViews    int        `json:"max_views"`
+	Folder      string     `json:"folder"`
+	// ExpectedUpdatedAt is the optimistic concurrency check of PUT, the same in every version
+	ExpectedUpdatedAt *time.Time `json:"expected_updated_at"`
+}
+
+// negotiateVersion returns the schema version asked for in the Accept header (the latest when
+// there is none), writing a 406 itself when the client asks only for versions we don't support
+func negotiateVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	var unsupported []string
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		m := vendorMediaType.FindStringSubmatch(mediaType)
+		if m == nil {
+			continue
+		}
+		if version, err := strconv.Atoi(m[1]); err == nil && version >= apiVersion1 && version <= apiVersionLatest {
+			w.Header().Set("X-API-Version", strconv.Itoa(version))
+			return version, true
+		}
+		unsupported = append(unsupported, mediaType)
+	}
+
+	if len(unsupported) > 0 {
+		writeError(w, http.StatusNotAcceptable, errCodeNotAcceptable,
+			fmt.Sprintf("unsupported version %s, supported versions are v1 to v%d", strings.Join(unsupported, ", "), apiVersionLatest))
+		return 0, false
+	}
+	w.Header().Set("X-API-Version", strconv.Itoa(apiVersionLatest))
+	return apiVersionLatest, true
+}
+
+// decodeSnippetBody decodes a create/update request body according to the negotiated schema
+// version, writing the 406 or 400 response itself when it can't
+func decodeSnippetBody(w http.ResponseWriter, r *http.Request, c *CodeSnippet) bool {
+	version, ok := negotiateVersion(w, r)
+	if !ok {
+		return false
+	}
+
+	var err error
+	switch version {
+	case apiVersion1:
+		var v1 codeSnippetV1
+		if err = json.NewDecoder(r.Body).Decode(&v1); err == nil {
+			*c = CodeSnippet{
+				SnippetName:       v1.SnippetName,
+				Code:              v1.Code,
+				Language:          v1.Language,
+				Tags:              v1.Tags,
+				ExpiresAt:         v1.ExpiresAt,
+				MaxViews:          v1.MaxViews,
+				Folder:            v1.Folder,
+				ExpectedUpdatedAt: v1.ExpectedUpdatedAt,
+			}
+		}
+	default:
+		err = json.NewDecoder(r.Body).Decode(c)
+	}
+	if err != nil {
+		writeDecodeError(w, r, err, "invalid request body")
+		return false
+	}
+	return true
+}
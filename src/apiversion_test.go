@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDecodeSnippetBodyV1 checks that every field of a v1 body reaches the snippet
+func TestDecodeSnippetBodyV1(t *testing.T) {
+	body := `{"snippetname": "hello", "code": "x", "language": "go", "tags": ["a"], "max_views": 1, "folder": "/go/http"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Accept", "application/vnd.snippets.v1+json")
+	rec := httptest.NewRecorder()
+
+	var c CodeSnippet
+	if !decodeSnippetBody(rec, req, &c) {
+		t.Fatalf("decodeSnippetBody refused the body: %d %s", rec.Code, rec.Body.String())
+	}
+	if c.SnippetName != "hello" || c.Code != "x" || c.Language != "go" || len(c.Tags) != 1 {
+		t.Errorf("decoded %+v", c)
+	}
+	if c.MaxViews != 1 {
+		t.Errorf("max_views = %d, want 1", c.MaxViews)
+	}
+	if c.Folder != "/go/http" {
+		t.Errorf("folder = %q, want /go/http", c.Folder)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+downloadArchive streams every snippet (or only those of ?language=) as a zip file, burn after
+reading snippets are left out.
+
+	Each snippet becomes a file named <snippetname><ext>, the extension comes from its language.
+	The zip is written straight to the ResponseWriter while the cursor is read, so memory use
+	doesn't grow with the number of snippets. Because the 200 status is sent with the first
+	bytes, an error halfway through can only be logged, the client gets a truncated zip.
+*/
+func downloadArchive(w http.ResponseWriter, r *http.Request) {
+	// burn after reading snippets only leave through a counted read
+	filter := bson.M{"maxViews": bson.M{"$exists": false}}
+	if language := normalizeLanguage(r.URL.Query().Get("language")); language != "" {
+		filter["language"] = language
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
+	if err != nil {
+		log.Printf("failed to fetch snippets for archive: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="code-snippets.zip"`)
+
+	zw := zip.NewWriter(w)
+	used := map[string]bool{}
+	for cursor.Next(r.Context()) {
+		var s CodeSnippetModel
+		if err := cursor.Decode(&s); err != nil {
+			log.Printf("failed to decode snippet for archive: %s\n", err)
+			return
+		}
+
+		ext := extensionFor(s.Language)
+		base := archiveFileBase(s.SnippetName)
+		name := base + ext
+		// two snippets may share a name (zip entries are compared case-insensitively by most tools)
+		if used[strings.ToLower(name)] {
+			name = base + "-" + s.ID.Hex() + ext
+		}
+		used[strings.ToLower(name)] = true
+
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.CreatedAt})
+		if err != nil {
+			log.Printf("failed to write archive entry: %s\n", err)
+			return
+		}
+		if _, err := f.Write([]byte(s.plainCode())); err != nil {
+			log.Printf("failed to write archive entry: %s\n", err)
+			return
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("failed to read snippets for archive: %s\n", err)
+		return
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("failed to finish archive: %s\n", err)
+	}
+}
+
+// archiveFileBase turns a snippet name into a safe file name: no directories, no special characters
+func archiveFileBase(name string) string {
+	base := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+
+	base = strings.Trim(base, ".")
+	if base == "" {
+		return "snippet"
+	}
+	return base
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notArchived is added to the filter of the default listings, snippets without the field
+// (everything stored before archiving existed) are not archived
+var notArchived = bson.M{"$ne": true}
+
+// withoutArchived leaves archived snippets out of a listing unless ?include_archived=true,
+// the same way withShardKey adds to a single snippet filter
+func withoutArchived(r *http.Request, filter bson.M) bson.M {
+	if queryBool(r, "include_archived") {
+		return filter
+	}
+	filter["archived"] = notArchived
+	return filter
+}
+
+// archiveSnippet hides a snippet from the default listings, it stays readable by id and name
+func archiveSnippet(w http.ResponseWriter, r *http.Request) {
+	setSnippetArchived(w, r, true)
+}
+
+// unarchiveSnippet brings an archived snippet back into the listings
+func unarchiveSnippet(w http.ResponseWriter, r *http.Request) {
+	setSnippetArchived(w, r, false)
+}
+
+/*
+setSnippetArchived is archiveSnippet and unarchiveSnippet.
+
+	Archiving is not a delete: the snippet, its comments, votes and history stay, single reads
+	still return it and ?include_archived=true or GET /archived list it. Like votes and shares
+	it is allowed on locked snippets, the lock only protects the content. Unarchiving removes
+	the fields again so the document looks like one that was never archived.
+*/
+func setSnippetArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"archived": true, "archivedAt": now, "updatedAt": now}}
+	if !archived {
+		update = bson.M{"$set": bson.M{"updatedAt": now}, "$unset": bson.M{"archived": "", "archivedAt": ""}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err := db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id}), update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update snippet archive state: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
+
+// getArchivedSnippets lists only the archived snippets, most recently archived first, paginated like getAllSnippets
+func getArchivedSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "archivedAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+	cursor, err := db.Collection(collectionName).Find(r.Context(), bson.M{"archived": true}, opts)
+	if err != nil {
+		log.Printf("failed to fetch archived snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	found := []CodeSnippetModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch archived snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+
+	snippets := []CodeSnippet{}
+	for _, s := range found {
+		snippets = append(snippets, snippetResponse(r, s))
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       snippets,
+		"pagination": page,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// autocompleteDefaultLimit is the number of suggestions returned when ?limit= is not given
+	autocompleteDefaultLimit = 10
+	// autocompleteMaxLimit caps ?limit=, a suggestion box never needs more than this
+	autocompleteMaxLimit = 25
+)
+
+// nameSuggestion is one autocomplete result, only the id and the name are loaded from the database
+type nameSuggestion struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	SnippetName string             `bson:"snippetname" json:"snippet_name"`
+}
+
+/*
+autocompleteSnippetNames suggests snippet names for ?prefix=, ignoring case.
+
+	Names starting with the prefix come first, the remaining slots are filled with names that
+	contain it somewhere else. The prefix is escaped, so it is always matched literally.
+	Note that a case-insensitive regex can't use the snippetname index as well as a
+	case-sensitive anchored one would, Mongo still has to scan the index keys.
+*/
+func autocompleteSnippetNames(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.URL.Query().Get("prefix"))
+	if n := utf8.RuneCountInString(prefix); n < 1 || n > cfg.MaxNameLen {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery,
+			fmt.Sprintf("prefix must be between 1 and %d characters", cfg.MaxNameLen))
+		return
+	}
+
+	limit := autocompleteDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "limit must be a positive integer")
+			return
+		}
+		if n < autocompleteMaxLimit {
+			limit = n
+		} else {
+			limit = autocompleteMaxLimit
+		}
+	}
+
+	quoted := regexp.QuoteMeta(prefix)
+	suggestions, err := findNameSuggestions(r, bson.M{
+		"snippetname": primitive.Regex{Pattern: "^" + quoted, Options: "i"},
+	}, limit)
+	if err != nil {
+		log.Printf("failed to autocomplete names: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch suggestions")
+		return
+	}
+
+	if len(suggestions) < limit {
+		seen := []primitive.ObjectID{}
+		for _, s := range suggestions {
+			seen = append(seen, s.ID)
+		}
+		more, err := findNameSuggestions(r, bson.M{
+			"_id":         bson.M{"$nin": seen},
+			"snippetname": primitive.Regex{Pattern: quoted, Options: "i"},
+		}, limit-len(suggestions))
+		if err != nil {
+			log.Printf("failed to autocomplete names: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch suggestions")
+			return
+		}
+		suggestions = append(suggestions, more...)
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": suggestions,
+	})
+}
+
+// findNameSuggestions runs one autocomplete query, loading only the id and name, sorted by name
+func findNameSuggestions(r *http.Request, filter bson.M, limit int) ([]nameSuggestion, error) {
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "snippetname": 1}).
+		SetSort(bson.D{{Key: "snippetname", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := db.Collection(collectionName).Find(r.Context(), withoutArchived(r, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []nameSuggestion{}
+	if err := cursor.All(r.Context(), &suggestions); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxBatchIDs caps how many ids a single batch request may carry
+	maxBatchIDs = 100
+	// maxBatchNames caps how many names a single batch-by-name request may carry
+	maxBatchNames = 100
+)
+
+// batchRequest is the body of the batch endpoints: {"ids": ["...", "..."]}
+type batchRequest struct {
+	IDs []string `json:"ids"`
+}
+
+/*
+decodeBatchIDs reads the ids of a batch request and parses each one on its own.
+
+	A malformed id doesn't fail the request, it ends up in invalid and the valid ids are still
+	processed. Duplicates are dropped so every id shows up exactly once in the response.
+	ok is false when a response has already been written.
+*/
+func decodeBatchIDs(w http.ResponseWriter, r *http.Request) (valid []primitive.ObjectID, invalid []string, ok bool) {
+	var body batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"ids": [...]}`)
+		return nil, nil, false
+	}
+	if len(body.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "ids must not be empty")
+		return nil, nil, false
+	}
+	if len(body.IDs) > maxBatchIDs {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("at most %d ids per request", maxBatchIDs))
+		return nil, nil, false
+	}
+
+	valid = []primitive.ObjectID{}
+	invalid = []string{}
+	seen := map[string]bool{}
+	for _, idstr := range body.IDs {
+		idstr = strings.TrimSpace(idstr)
+		if seen[idstr] {
+			continue
+		}
+		seen[idstr] = true
+
+		id, err := primitive.ObjectIDFromHex(idstr)
+		if err != nil {
+			invalid = append(invalid, idstr)
+			continue
+		}
+		valid = append(valid, id)
+	}
+	return valid, invalid, true
+}
+
+// missingIDs returns the ids of want that are not in have
+func missingIDs(want []primitive.ObjectID, have map[primitive.ObjectID]bool) []string {
+	missing := []string{}
+	for _, id := range want {
+		if !have[id] {
+			missing = append(missing, id.Hex())
+		}
+	}
+	return missing
+}
+
+// batchGetSnippets returns the snippets for a list of ids, listing which ids were invalid or not found
+func batchGetSnippets(w http.ResponseWriter, r *http.Request) {
+	ids, invalid, ok := decodeBatchIDs(w, r)
+	if !ok {
+		return
+	}
+
+	snippets := []CodeSnippetModel{}
+	if len(ids) > 0 {
+		cursor, err := db.Collection(collectionName).Find(r.Context(), bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			log.Printf("failed to fetch snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+			return
+		}
+		if err := cursor.All(r.Context(), &snippets); err != nil {
+			log.Printf("failed to fetch snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+			return
+		}
+	}
+
+	found := map[primitive.ObjectID]bool{}
+	snippetsList := []CodeSnippet{}
+	for _, s := range snippets {
+		found[s.ID] = true
+		snippetsList = append(snippetsList, snippetResponse(r, s))
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":      snippetsList,
+		"invalid":   invalid,
+		"not_found": missingIDs(ids, found),
+	})
+}
+
+/*
+batchGetSnippetsByName returns the snippets for a list of names, body: {"names": ["...", "..."]}.
+
+	The names are matched exactly (case-sensitive, like GET /{snippetName} without ?ci=true)
+	with a single $in on snippetname, which the snippetname_1 index serves. Names are trimmed
+	and duplicates dropped, the names without a snippet are listed under "not_found".
+*/
+func batchGetSnippetsByName(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Names []string `json:"names"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"names": [...]}`)
+		return
+	}
+	if len(body.Names) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "names must not be empty")
+		return
+	}
+	if len(body.Names) > maxBatchNames {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("at most %d names per request", maxBatchNames))
+		return
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range body.Names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	snippets := []CodeSnippetModel{}
+	if len(names) > 0 {
+		cursor, err := db.Collection(collectionName).Find(r.Context(), bson.M{"snippetname": bson.M{"$in": names}})
+		if err != nil {
+			log.Printf("failed to fetch snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+			return
+		}
+		if err := cursor.All(r.Context(), &snippets); err != nil {
+			log.Printf("failed to fetch snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+			return
+		}
+	}
+
+	found := map[string]bool{}
+	snippetsList := []CodeSnippet{}
+	for _, s := range snippets {
+		found[s.SnippetName] = true
+		snippetsList = append(snippetsList, snippetResponse(r, s))
+	}
+	notFound := []string{}
+	for _, name := range names {
+		if !found[name] {
+			notFound = append(notFound, name)
+		}
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":      snippetsList,
+		"not_found": notFound,
+	})
+}
+
+/*
+bulkDeleteSnippets deletes a list of snippets by id.
+
+	The existing ids are looked up first so the response can tell deleted ids apart from ids
+	that didn't exist, then those are removed with a single DeleteMany. Locked snippets are
+	left alone and listed under "locked".
+*/
+func bulkDeleteSnippets(w http.ResponseWriter, r *http.Request) {
+	ids, invalid, ok := decodeBatchIDs(w, r)
+	if !ok {
+		return
+	}
+
+	existing := []CodeSnippetModel{}
+	if len(ids) > 0 {
+		opts := options.Find().SetProjection(bson.M{"_id": 1, "locked": 1})
+		cursor, err := db.Collection(collectionName).Find(r.Context(), bson.M{"_id": bson.M{"$in": ids}}, opts)
+		if err != nil {
+			log.Printf("failed to look up snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to delete snippets")
+			return
+		}
+		if err := cursor.All(r.Context(), &existing); err != nil {
+			log.Printf("failed to look up snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to delete snippets")
+			return
+		}
+	}
+
+	found := map[primitive.ObjectID]bool{}
+	deleteIDs := []primitive.ObjectID{}
+	deleted := []string{}
+	locked := []string{}
+	for _, s := range existing {
+		found[s.ID] = true
+		if s.Locked {
+			locked = append(locked, s.ID.Hex())
+			continue
+		}
+		deleteIDs = append(deleteIDs, s.ID)
+		deleted = append(deleted, s.ID.Hex())
+	}
+
+	if len(deleteIDs) > 0 {
+		_, err := db.Collection(collectionName).DeleteMany(r.Context(), bson.M{"_id": bson.M{"$in": deleteIDs}, "locked": notLocked})
+		snippetCache.invalidate(deleteIDs...)
+		if err != nil {
+			log.Printf("failed to delete snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to delete snippets")
+			return
+		}
+		deleteSnippetDependents(r.Context(), bson.M{"snippetId": bson.M{"$in": deleteIDs}})
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"deleted":   deleted,
+		"invalid":   invalid,
+		"not_found": missingIDs(ids, found),
+		"locked":    locked,
+	})
+}
+
+// batchUpdatableFields are the fields a filtered batch update may set. Names are unique and the
+// code belongs to one snippet, so setting either on many snippets at once makes no sense.
+var batchUpdatableFields = map[string]bool{
+	"language":   true,
+	"tags":       true,
+	"sort_order": true,
+	"expires_at": true,
+}
+
+/*
+batchUpdateSnippets sets fields on every snippet matching the list filters (PATCH /code-snippets),
+e.g. PATCH /code-snippets?language=python with {"tags": ["python", "legacy"]}.
+
+	At least one filter is required, a PATCH without one would touch every snippet. The values
+	go through the same checks as a single update, then one UpdateMany writes them. Locked
+	snippets are skipped, archived ones too unless ?include_archived=true. The response has the
+	number of matched and modified snippets.
+*/
+func batchUpdateSnippets(w http.ResponseWriter, r *http.Request) {
+	filter, err := snippetListFilter(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+	if len(filter) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "a filter (e.g. ?language= or ?tag=) is required to update several snippets")
+		return
+	}
+	// only after the check, the archived clause alone isn't a filter the client chose
+	filter = withoutArchived(r, filter)
+
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || len(fields) == 0 {
+		writeDecodeError(w, r, err, "the request body must be a JSON object with the fields to set")
+		return
+	}
+
+	var c CodeSnippet
+	for key, raw := range fields {
+		if !batchUpdatableFields[key] {
+			writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("%s can't be set on several snippets, only language, tags, sort_order and expires_at can", key))
+			return
+		}
+		if string(raw) == "null" {
+			writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("%s must not be null", key))
+			return
+		}
+	}
+	// the set fields decode into a CodeSnippet so validateSnippet can check them like a single update
+	body, _ := json.Marshal(fields)
+	if err := json.Unmarshal(body, &c); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "invalid request body")
+		return
+	}
+	var errs []fieldError
+	for _, e := range validateSnippet(c) {
+		if _, ok := fields[e.Field]; ok {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	update := bson.M{"$set": set}
+	for key := range fields {
+		switch key {
+		case "language":
+			set["language"] = normalizeLanguage(c.Language)
+		case "tags":
+			set["tags"] = c.Tags
+		case "sort_order":
+			set["sortOrder"] = c.SortOrder
+		case "expires_at":
+			set["expiresAt"] = c.ExpiresAt
+			update["$unset"] = bson.M{"expiryRemindedAt": ""}
+		}
+	}
+
+	filter = withShardKey(r, bson.M{"$and": bson.A{filter, bson.M{"locked": notLocked}}})
+	result, err := db.Collection(collectionName).UpdateMany(r.Context(), filter, update)
+	// which snippets changed isn't known here, the whole cache goes
+	snippetCache.purge()
+	if err != nil {
+		log.Printf("failed to update snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippets")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":  "Snippets updated successfully",
+		"matched":  result.MatchedCount,
+		"modified": result.ModifiedCount,
+	})
+}
+
+// maxBulkSnippets caps how many snippets a single bulk create may carry
+const maxBulkSnippets = 100
+
+// bulkCreateResult is the outcome of one snippet of a bulk create
+type bulkCreateResult struct {
+	Index       int    `json:"index"`
+	SnippetName string `json:"snippet_name,omitempty"`
+	// Status is "created", "invalid" (failed validation) or "failed" (the insert failed)
+	Status  string       `json:"status"`
+	ID      string       `json:"id,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Errors  []fieldError `json:"errors,omitempty"`
+}
+
+/*
+bulkCreateSnippets creates several snippets at once, body: {"snippets": [{...}, {...}]}.
+
+	Every snippet is validated on its own like a create (an item that isn't even a snippet
+	object is "invalid" too), the valid ones are inserted together by insertSnippetsUnordered,
+	so a duplicate name only fails its own item. The response lists every item by its index in
+	the request: 201 when all were created, otherwise 207 Multi-Status with the mixed results.
+	?preserve_timestamps=true keeps the created_at of every item like on create. With
+	SNIPPET_OWNERSHIP on the valid items count against MAX_SNIPPETS_PER_OWNER together, see
+	takeBatchOwnership.
+*/
+func bulkCreateSnippets(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Snippets []json.RawMessage `json:"snippets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"snippets": [...]}`)
+		return
+	}
+	if len(body.Snippets) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "snippets must not be empty")
+		return
+	}
+	if len(body.Snippets) > maxBulkSnippets {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("at most %d snippets per request", maxBulkSnippets))
+		return
+	}
+
+	preserve := queryBool(r, "preserve_timestamps")
+	source, ok := requestSource(w, r)
+	if !ok {
+		return
+	}
+	results := make([]bulkCreateResult, len(body.Snippets))
+	models := []CodeSnippetModel{}
+	// indexes maps a position in models back to the position in the request
+	indexes := []int{}
+	for i, raw := range body.Snippets {
+		m, message, errs := prepareSnippetDoc(raw, preserve, source)
+		results[i] = bulkCreateResult{Index: i, SnippetName: m.SnippetName}
+		if message != "" || len(errs) > 0 {
+			results[i].Status, results[i].Message, results[i].Errors = "invalid", message, errs
+			continue
+		}
+		models = append(models, m)
+		indexes = append(indexes, i)
+	}
+
+	if !takeBatchOwnership(w, r, models) {
+		return
+	}
+	failed, err := insertSnippetsUnordered(r.Context(), models)
+	if err != nil {
+		log.Printf("failed to save snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save Code Snippets")
+		return
+	}
+
+	created := 0
+	for n, m := range models {
+		i := indexes[n]
+		if reason, ok := failed[n]; ok {
+			results[i].Status, results[i].Message = "failed", reason
+			continue
+		}
+		results[i].Status, results[i].ID = "created", m.ID.Hex()
+		created++
+	}
+
+	status := http.StatusCreated
+	if created < len(results) {
+		status = http.StatusMultiStatus
+	}
+	rnd.JSON(w, status, renderer.M{
+		"created": created,
+		"failed":  len(results) - created,
+		"results": results,
+	})
+}
+
+/*
+insertSnippetsUnordered inserts the snippets with a single unordered InsertMany.
+
+	Unordered means Mongo keeps going after a failing document, so one duplicate name doesn't
+	stop the others. The BulkWriteException lists the failures by their index in models, they
+	come back in failed with the reason for the client. err is only set when the insert as a
+	whole failed (e.g. the database is unreachable or a write concern error), then it is
+	unknown which documents were written.
+	With GENERATE_SLUGS on every snippet gets a slug first (see assignBatchSlugs), one that
+	lost its slug to a concurrent create is inserted again on its own through insertWithSlug.
+*/
+func insertSnippetsUnordered(ctx context.Context, models []CodeSnippetModel) (failed map[int]string, err error) {
+	failed = map[int]string{}
+	if len(models) == 0 {
+		return failed, nil
+	}
+
+	if err := assignBatchSlugs(ctx, models); err != nil {
+		return failed, err
+	}
+	docs := make([]interface{}, len(models))
+	for i := range models {
+		docs[i] = &models[i]
+	}
+	_, err = db.Collection(collectionName).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		for _, e := range bulkErr.WriteErrors {
+			if e.Code == duplicateKeyCode && strings.Contains(e.Message, slugIndexName) {
+				m := &models[e.Index]
+				err := insertWithSlug(ctx, m, func() error {
+					_, err := db.Collection(collectionName).InsertOne(ctx, m)
+					return err
+				})
+				if err == nil {
+					continue
+				}
+				// a duplicate name falls through, the slug index only when all attempts lost
+				if !isDuplicateKey(err) || isDuplicateSlug(err) {
+					log.Printf("failed to insert snippet %q: %s\n", m.SnippetName, err)
+					failed[e.Index] = "Failed to save Code Snippet"
+					continue
+				}
+			}
+			if e.Code == duplicateKeyCode {
+				failed[e.Index] = fmt.Sprintf("a snippet named %q already exists", models[e.Index].SnippetName)
+				continue
+			}
+			log.Printf("failed to insert snippet %q: %s\n", models[e.Index].SnippetName, e.Message)
+			failed[e.Index] = "Failed to save Code Snippet"
+		}
+		return failed, nil
+	}
+	return failed, err
+}
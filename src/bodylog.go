@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// redactedFields matches a JSON string value of a sensitive key, the value is replaced before logging.
+// It works on truncated bodies too, which a JSON decoder wouldn't.
+var redactedFields = regexp.MustCompile(`("(?i:password|passwd|secret|token|access_token|api_key|apikey|authorization|cookie)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// redactBody hides the values of sensitive fields
+func redactBody(body []byte) string {
+	return redactedFields.ReplaceAllString(string(body), `$1"[REDACTED]"`)
+}
+
+// truncatingBuffer keeps the first max bytes written to it and silently drops the rest
+type truncatingBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *truncatingBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room < len(p) {
+		b.truncated = true
+		p = p[:room]
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+/*
+bodyLogger logs the request and response bodies of write requests (POST, PUT, PATCH, DELETE)
+for debugging.
+
+	It is only installed with DEBUG_LOG_BODIES=true, which loadConfig refuses when APP_ENV is
+	production. Each body is logged up to DEBUG_LOG_BODY_BYTES with the values of sensitive
+	fields (password, token, api_key, ...) redacted. The request body is read through a
+	bufio.Reader and only peeked at, the handler still reads every byte of it. Reads from the
+	body count against bodyLimit as usual, a rejected body is logged as far as it was read.
+*/
+func bodyLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		max := cfg.DebugLogBodyBytes
+		br := bufio.NewReaderSize(r.Body, max+1)
+		// Peek returns what it could read along with an error when the body is shorter, that's fine
+		peeked, _ := br.Peek(max + 1)
+		reqTruncated := len(peeked) > max
+		if reqTruncated {
+			peeked = peeked[:max]
+		}
+		requestBody := redactBody(peeked)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, r.Body}
+
+		response := &truncatingBuffer{max: max}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(response)
+		next.ServeHTTP(ww, r)
+
+		log.Printf("debug body method=%s path=%q status=%d request=%q request_truncated=%t response=%q response_truncated=%t\n",
+			r.Method, r.URL.RequestURI(), ww.Status(), requestBody, reqTruncated, redactBody(response.buf), response.truncated)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+/*
+circuitBreaker stops sending requests to Mongo while it keeps failing.
+
+	closed:    requests pass, consecutive failures are counted
+	open:      after threshold consecutive failures every request fails fast until cooldown passed
+	half-open: one probe request is let through, success closes the breaker, failure opens it again
+*/
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+}
+
+// dbBreaker guards every route that talks to Mongo, configured from cfg in init()
+var dbBreaker *circuitBreaker
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{state: breakerClosed, threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may go to the database right now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// only one probe at a time, the rest keeps failing fast until it reported back
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record reports the outcome of a request that allow() let through
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = breakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+// snapshot returns the current state for /status
+func (b *circuitBreaker) snapshot() (state string, failures int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures
+}
+
+/*
+breakerMiddleware fails requests fast with a 503 while the breaker is open.
+
+	The handlers only answer with a 5xx when a Mongo operation failed, so the response status
+	is the failure signal: 5xx counts as a database failure, anything else as a success.
+	503s sent by the service itself (read-only mode, the breaker) never reach this point.
+*/
+func breakerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dbBreaker.allow() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(dbBreaker.cooldown.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, errCodeDatabaseUnavailable,
+				"the database is currently unavailable, please try again later")
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		success := false
+		defer func() {
+			// a panicking handler counts as a failure and still releases a half-open probe
+			dbBreaker.record(success)
+		}()
+		next.ServeHTTP(ww, r)
+		success = ww.Status() < http.StatusInternalServerError
+	})
+}
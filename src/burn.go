@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+consumeView counts one read of a snippet with max_views ("burn after reading") and returns it
+with the updated view count, writing the 404 itself when no views are left.
+
+	The increment only matches while views < maxViews, so of two concurrent reads of a one-view
+	snippet exactly one gets the document back, the other one finds nothing and gets the 404.
+	The read that uses up the last view deletes the snippet (with its comments, votes and history)
+	before the response is written, the content is already in memory. The lock doesn't protect
+	against that, a burn snippet was meant to go. X-Views-Remaining tells the client how many
+	reads are left. In read-only mode a view can't be counted, the read is refused with the 503
+	of readOnlyGuard instead of handing out an uncounted copy.
+*/
+func consumeView(w http.ResponseWriter, r *http.Request, s CodeSnippetModel) (CodeSnippetModel, bool) {
+	if readOnly.Load() {
+		writeError(w, http.StatusServiceUnavailable, errCodeReadOnly,
+			"the service is in read-only mode for maintenance, please try again later")
+		return s, false
+	}
+	filter := withShardKey(r, bson.M{"_id": s.ID, "views": bson.M{"$lt": s.MaxViews}})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var viewed CodeSnippetModel
+	err := db.Collection(collectionName).FindOneAndUpdate(r.Context(), filter, bson.M{"$inc": bson.M{"views": 1}}, opts).Decode(&viewed)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return viewed, false
+	}
+	if err != nil {
+		log.Printf("failed to count view: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return viewed, false
+	}
+
+	remaining := viewed.MaxViews - viewed.Views
+	if remaining <= 0 {
+		remaining = 0
+		burnSnippet(viewed)
+	}
+	w.Header().Set("X-Views-Remaining", strconv.Itoa(remaining))
+	// a proxy must not hand out a copy, every read has to count
+	w.Header().Set("Cache-Control", "no-store")
+	return viewed, true
+}
+
+// burnSnippet deletes a snippet whose last view was just used. It runs with its own timeout so
+// a client hanging up doesn't leave the snippet behind, a failure is logged; the views stay
+// used up, so the snippet can't be read again either way.
+func burnSnippet(s CodeSnippetModel) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Collection(collectionName).DeleteOne(ctx, bson.M{"_id": s.ID}); err != nil {
+		log.Printf("failed to delete read snippet %s: %s\n", s.ID.Hex(), err)
+		return
+	}
+	snippetCache.invalidate(s.ID)
+	deleteSnippetDependents(ctx, bson.M{"snippetId": s.ID})
+}
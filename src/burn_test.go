@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestConsumeViewReadOnly checks that a burn snippet isn't handed out uncounted while the service is read-only
+func TestConsumeViewReadOnly(t *testing.T) {
+	old := readOnly.Load()
+	readOnly.Store(true)
+	t.Cleanup(func() { readOnly.Store(old) })
+
+	s := newSnippetModel(CodeSnippet{SnippetName: "secret", Code: "x", MaxViews: 1})
+	rec := httptest.NewRecorder()
+	// db is nil in unit tests, counting the view would panic
+	if _, ok := consumeView(rec, httptest.NewRequest(http.MethodGet, "/", nil), s); ok {
+		t.Fatal("consumeView handed out the snippet while read-only")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rec.Code)
+	}
+	if code := errorCode(t, rec); code != errCodeReadOnly {
+		t.Errorf("code = %s, want %s", code, errCodeReadOnly)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// lastModified is the time of the last change to the snippet, its creation time if it was never updated
+func (m CodeSnippetModel) lastModified() time.Time {
+	if m.UpdatedAt != nil {
+		return *m.UpdatedAt
+	}
+	return m.CreatedAt
+}
+
+/*
+notModified sets the caching headers of a single snippet response and answers conditional requests.
+
+	Last-Modified is the snippet's last change and Cache-Control is CACHE_CONTROL_SNIPPET (by
+	default max-age of CACHE_MAX_AGE seconds). When the client sends If-Modified-Since and the
+	snippet hasn't changed since, a bodyless 304 is written and true returned, the handler must
+	not write anything else. HTTP dates only have second precision, so the modification time is
+	truncated before comparing.
+*/
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.UTC().Truncate(time.Second)
+
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", cfg.CacheControlSnippet)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+/*
+cacheControl sets a Cache-Control header on the successful GET and HEAD responses of the routes
+it wraps, e.g. CACHE_CONTROL_LIST on the list endpoints.
+
+	The header is only added when the response status is written, so errors (which must not end
+	up in a shared cache) don't get it and a handler that sets Cache-Control itself keeps its own.
+*/
+func cacheControl(value string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, value: value}, r)
+		})
+	}
+}
+
+// cacheControlWriter adds the Cache-Control of cacheControl when the status is written
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (cw *cacheControlWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status < http.StatusBadRequest && cw.Header().Get("Cache-Control") == "" {
+			cw.Header().Set("Cache-Control", cw.value)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheControlWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the Flusher of the wrapped writer (NDJSON lists)
+func (cw *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
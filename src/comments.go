@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// commentsCollectionName holds the comments of all snippets, each one points to its snippet by snippetId
+	commentsCollectionName = "code-snippet-comments"
+	// maxCommentLen and maxAuthorLen are in characters
+	maxCommentLen = 2000
+	maxAuthorLen  = 100
+)
+
+type (
+	// commentModel is a comment as stored in the database
+	commentModel struct {
+		ID        primitive.ObjectID `bson:"_id,omitempty"`
+		SnippetID primitive.ObjectID `bson:"snippetId"`
+		Author    string             `bson:"author"`
+		Text      string             `bson:"text"`
+		CreatedAt time.Time          `bson:"createdAt"`
+	}
+	// comment is a comment as sent to and received from the client
+	comment struct {
+		ID        string    `json:"id"`
+		SnippetID string    `json:"snippet_id"`
+		Author    string    `json:"author"`
+		Text      string    `json:"text"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+)
+
+func toComment(m commentModel) comment {
+	return comment{
+		ID:        m.ID.Hex(),
+		SnippetID: m.SnippetID.Hex(),
+		Author:    m.Author,
+		Text:      m.Text,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// validateComment checks a new comment, returning every problem found like validateSnippet
+func validateComment(c comment) []fieldError {
+	var errs []fieldError
+
+	if c.Author == "" {
+		errs = append(errs, fieldError{Field: "author", Code: errCodeAuthorRequired, Message: "the author field is requested"})
+	} else if utf8.RuneCountInString(c.Author) > maxAuthorLen {
+		errs = append(errs, fieldError{
+			Field:   "author",
+			Code:    errCodeAuthorTooLong,
+			Message: fmt.Sprintf("the author must not exceed %d characters", maxAuthorLen),
+			Limit:   maxAuthorLen,
+		})
+	}
+
+	if c.Text == "" {
+		errs = append(errs, fieldError{Field: "text", Code: errCodeCommentRequired, Message: "the text field is requested"})
+	} else if utf8.RuneCountInString(c.Text) > maxCommentLen {
+		errs = append(errs, fieldError{
+			Field:   "text",
+			Code:    errCodeCommentTooLong,
+			Message: fmt.Sprintf("a comment must not exceed %d characters", maxCommentLen),
+			Limit:   maxCommentLen,
+		})
+	}
+
+	return errs
+}
+
+// addSnippetComment adds a comment to a snippet, body: {"author": "...", "text": "..."}.
+// Locked snippets can still be commented on, the lock only protects the snippet itself.
+func addSnippetComment(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var c comment
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"author": "...", "text": "..."}`)
+		return
+	}
+	c.Author = strings.TrimSpace(c.Author)
+	c.Text = strings.TrimSpace(c.Text)
+	if errs := validateComment(c); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+
+	if !snippetExists(w, r, id) {
+		return
+	}
+
+	m := commentModel{
+		ID:        primitive.NewObjectID(),
+		SnippetID: id,
+		Author:    c.Author,
+		Text:      c.Text,
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.Collection(commentsCollectionName).InsertOne(r.Context(), m); err != nil {
+		log.Printf("failed to save comment: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save comment")
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Comment added successfully",
+		"data":    toComment(m),
+	})
+}
+
+// getSnippetComments lists the comments of a snippet oldest first, paginated like getAllSnippets
+func getSnippetComments(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	if !snippetExists(w, r, id) {
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+	cursor, err := db.Collection(commentsCollectionName).Find(r.Context(), bson.M{"snippetId": id}, opts)
+	if err != nil {
+		log.Printf("failed to fetch comments: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch comments")
+		return
+	}
+	found := []commentModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch comments: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch comments")
+		return
+	}
+
+	comments := []comment{}
+	for _, m := range found {
+		comments = append(comments, toComment(m))
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       comments,
+		"pagination": page,
+	})
+}
+
+// snippetExists reports whether the snippet exists, writing the 404 (or 500) itself when it doesn't
+func snippetExists(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) bool {
+	n, err := db.Collection(collectionName).CountDocuments(r.Context(), withShardKey(r, bson.M{"_id": id}), options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return false
+	}
+	if n == 0 {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return false
+	}
+	return true
+}
+
+// deleteSnippetDependents removes the comments, votes and history of deleted snippets (everything when filter
+// is empty). A failure is only logged, orphans are never listed because their snippet is gone.
+func deleteSnippetDependents(ctx context.Context, filter bson.M) {
+	for _, name := range []string{commentsCollectionName, votesCollectionName, historyCollectionName} {
+		if _, err := db.Collection(name).DeleteMany(ctx, filter); err != nil {
+			log.Printf("failed to delete from %s: %s\n", name, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// compareMaxLines caps the lines on either side of a compare, the line diff is quadratic in them
+const compareMaxLines = 5000
+
+/*
+compareSnippet compares the code in the body with a stored snippet, body: {"code": "..."}.
+
+	identical is an exact byte comparison. similarity is a line based ratio between 0 and 1:
+	twice the number of lines the longest common subsequence keeps, divided by the lines of
+	both sides (like Python's difflib ratio, on lines), so reordered blocks lower it and a
+	changed line counts as one removed and one added. Lines are compared with a trailing \r
+	dropped, CRLF and LF versions of the same code are similar but not identical. Nothing is
+	written, the snippet isn't even marked as accessed. The code may be at most MAX_CODE_BYTES
+	and either side at most compareMaxLines lines.
+*/
+func compareSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"code": "..."}`)
+		return
+	}
+	if body.Code == "" {
+		writeError(w, http.StatusBadRequest, errCodeCodeRequired, "the code input field is requested")
+		return
+	}
+	if len(body.Code) > cfg.MaxCodeBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+			fmt.Sprintf("the code must not exceed %d bytes", cfg.MaxCodeBytes))
+		return
+	}
+
+	var stored CodeSnippetModel
+	err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+	if stored.MaxViews > 0 {
+		writeError(w, http.StatusConflict, errCodeConflict, "a burn after reading snippet can only be read through GET /code-snippets/id/{codeid}")
+		return
+	}
+
+	code := stored.plainCode()
+	storedLines, submittedLines := codeLines(code), codeLines(body.Code)
+	if len(storedLines) > compareMaxLines || len(submittedLines) > compareMaxLines {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+			fmt.Sprintf("both sides of a compare must not exceed %d lines", compareMaxLines))
+		return
+	}
+
+	common := commonLines(storedLines, submittedLines)
+	similarity := 1.0
+	if total := len(storedLines) + len(submittedLines); total > 0 {
+		similarity = float64(2*common) / float64(total)
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"snippet_id":    id.Hex(),
+		"identical":     code == body.Code,
+		"similarity":    math.Round(similarity*10000) / 10000,
+		"common_lines":  common,
+		"lines_removed": len(storedLines) - common,
+		"lines_added":   len(submittedLines) - common,
+	})
+}
+
+// codeLines splits code into lines without their \r, empty code has no lines
+func codeLines(code string) []string {
+	if code == "" {
+		return nil
+	}
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// commonLines is the length of the longest common subsequence of a and b, computed with two rows
+// of the dynamic programming table so memory stays linear
+func commonLines(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] >= cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+setCode stores code on the model the way it goes into the database.
+
+	Code larger than cfg.CompressThreshold bytes is gzip compressed into CodeCompressed and
+	the plain code field is left empty, smaller code is stored as is. CodeBytes always holds
+	the uncompressed size so size based queries don't have to decompress anything, CodeHash
+	the hash of the uncompressed code.
+	Compressed snippets are left out of the text index/regex search on code (the plain code
+	isn't stored), they can still be found by name.
+*/
+func setCode(m *CodeSnippetModel, code string) {
+	m.Code = code
+	m.CodeCompressed = nil
+	m.Compressed = false
+	m.CodeBytes = len(code)
+	m.CodeHash = codeHash(code)
+
+	if cfg.CompressThreshold <= 0 || len(code) <= cfg.CompressThreshold {
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, code); err != nil {
+		log.Printf("failed to compress code, storing it uncompressed: %s\n", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("failed to compress code, storing it uncompressed: %s\n", err)
+		return
+	}
+
+	m.Code = ""
+	m.CodeCompressed = buf.Bytes()
+	m.Compressed = true
+}
+
+// plainCode returns the snippet's code, decompressing it when it is stored compressed
+func (m CodeSnippetModel) plainCode() string {
+	if !m.Compressed {
+		return m.Code
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(m.CodeCompressed))
+	if err != nil {
+		log.Printf("failed to decompress code of snippet %s: %s\n", m.ID.Hex(), err)
+		return ""
+	}
+	code, err := io.ReadAll(zr)
+	if err != nil {
+		log.Printf("failed to decompress code of snippet %s: %s\n", m.ID.Hex(), err)
+		return ""
+	}
+	return string(code)
+}
+
+// codeUpdate returns the $set and $unset documents that store code on an existing snippet
+func codeUpdate(code string) (set bson.M, unset bson.M) {
+	var m CodeSnippetModel
+	setCode(&m, code)
+
+	set = bson.M{"code": m.Code, "codeBytes": m.CodeBytes, "codeHash": m.CodeHash}
+	if m.Compressed {
+		set["codeCompressed"] = m.CodeCompressed
+		set["compressed"] = true
+		return set, nil
+	}
+	return set, bson.M{"codeCompressed": "", "compressed": ""}
+}
+
+var (
+	// errAppendTooLarge means the code would exceed MAX_CODE_BYTES after appending
+	errAppendTooLarge = errors.New("appended code exceeds the maximum code size")
+	// errAppendConflict means the code kept changing while we tried to append to it
+	errAppendConflict = errors.New("snippet was modified concurrently")
+)
+
+/*
+appendCompressedCode appends text to a snippet whose code is stored compressed.
+
+	Compressed code can't be concatenated inside Mongo, so this is a fetch-append-write: the
+	code is decompressed, appended and written back only if codeCompressed still holds what we
+	read (compare-and-swap). If another write got in between we reload and retry a few times.
+*/
+func appendCompressedCode(ctx context.Context, current CodeSnippetModel, text string) (CodeSnippetModel, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		code := current.plainCode()
+		if code != "" {
+			code += "\n"
+		}
+		code += text
+		if len(code) > cfg.MaxCodeBytes {
+			return current, errAppendTooLarge
+		}
+
+		set, unset := codeUpdate(code)
+		set["updatedAt"] = time.Now()
+		update := bson.M{"$set": set}
+		if unset != nil {
+			update["$unset"] = unset
+		}
+		filter := bson.M{"_id": current.ID, "compressed": true, "codeCompressed": current.CodeCompressed, "locked": notLocked}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated CodeSnippetModel
+		err := db.Collection(collectionName).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+		if err != mongo.ErrNoDocuments {
+			return updated, err
+		}
+
+		// someone else changed the code, start over from the current version
+		if err := db.Collection(collectionName).FindOne(ctx, bson.M{"_id": current.ID}).Decode(&current); err != nil {
+			return current, err
+		}
+		if current.Locked {
+			return current, errSnippetLocked
+		}
+		if !current.Compressed {
+			// it was rewritten uncompressed in the meantime, the caller's atomic path can't be reused from here
+			return current, errAppendConflict
+		}
+	}
+	return current, errAppendConflict
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds every setting read from the environment. It is loaded once in init()
+// and only read afterwards, handlers use the global cfg.
+type config struct {
+	// validation limits
+	MinNameLen   int
+	MaxNameLen   int
+	MaxCodeBytes int
+	MaxTags      int
+	// AllowedLanguages restricts the language field, empty means any language is accepted
+	AllowedLanguages []string
+	// OwnershipEnabled records the X-User-ID of a create as the snippet's owner, MaxSnippetsPerOwner
+	// caps how many snippets one owner has, 0 is unlimited. See takeOwnership.
+	OwnershipEnabled    bool
+	MaxSnippetsPerOwner int
+	// GenerateSlugs gives every created snippet a slug of its name, see insertWithSlug
+	GenerateSlugs bool
+	// LanguageExtensions adds to or overrides the built-in language to file extension map, see extensionFor
+	LanguageExtensions map[string]string
+
+	// code larger than this many bytes is stored gzip compressed, 0 disables compression
+	CompressThreshold int
+
+	// MaxBodyBytes caps the size of every request body, see bodyLimit
+	MaxBodyBytes int64
+
+	// PreviewLines is the number of code lines of a ?preview=true snippet
+	PreviewLines int
+
+	// listing, DefaultPageSize is the limit when ?limit= is missing, MaxPageSize caps it
+	DefaultPageSize int
+	MaxPageSize     int
+
+	// ?regex=true search patterns are refused when longer than SearchRegexMaxLength bytes or
+	// nesting groups and quantifiers deeper than SearchRegexMaxDepth, see parseSearchRegex
+	SearchRegexMaxLength int
+	SearchRegexMaxDepth  int
+
+	// responses
+	ResponseEnvelope bool
+	// TimestampFormat is the default format of snippet timestamps: rfc3339 or unix_ms, see timestampFormat
+	TimestampFormat string
+	// CacheMaxAge is the Cache-Control max-age of single snippet reads, 0 sends no-cache
+	CacheMaxAge int
+	// CacheControlSnippet and CacheControlList are the Cache-Control values of single snippet
+	// reads and of the list endpoints, CacheControlSnippet defaults to what CacheMaxAge gives
+	CacheControlSnippet string
+	CacheControlList    string
+	// SnippetCacheSize is the number of snippets getSnippetByID keeps in memory, 0 disables the cache
+	SnippetCacheSize int
+
+	// MaxConcurrent is the number of requests handled at once, 0 means unlimited
+	MaxConcurrent int
+	// MaxWatchers is the number of open WebSocket watchers (see watchSnippet), 0 disables watching
+	MaxWatchers int
+
+	// RequiredIndexes are index names /readyz checks for, empty skips the check
+	RequiredIndexes []string
+
+	// WarmupConnections is the number of Mongo connections opened before serving, 0 skips the warmup
+	WarmupConnections int
+
+	// ShutdownTimeout is how long in flight requests may take to finish on shutdown
+	ShutdownTimeout time.Duration
+	// RequestTimeout is the overall deadline of a request, 0 disables it. Streaming endpoints are exempt.
+	RequestTimeout time.Duration
+
+	// view counts are written every ViewFlushInterval or once ViewFlushCount views are pending, see viewCounter
+	ViewFlushInterval time.Duration
+	ViewFlushCount    int
+
+	// ExpirySweepInterval is how often snippets expiring soon are looked for, 0 disables the reminders
+	ExpirySweepInterval time.Duration
+	// HashBackfillInterval is how often snippets missing codeHash are hashed (see runHashBackfill), 0 disables it
+	HashBackfillInterval time.Duration
+
+	// MongoReadPreference and MongoWriteConcern are applied to the Mongo client, empty keeps the
+	// connection string's (by default primary and w:1), see applyConsistency.
+	//
+	// secondaryPreferred (or secondary, nearest) spreads the reads over the replica set, but a
+	// secondary can lag behind: a snippet read right after it was created or changed may be
+	// missing or old, and lists may not show it yet. The read-then-write paths (PATCH, rename,
+	// history versions) read through the same client, their conflicts are still caught by the
+	// write filters and the unique indexes, they are just more likely.
+	// majority waits until most members have the write, so an acknowledged write survives a
+	// failover, at the cost of latency and of writes failing while most members are down.
+	// 1 acknowledges as soon as the primary has it, the fastest, but a failover can roll an
+	// acknowledged write back.
+	MongoReadPreference string
+	MongoWriteConcern   string
+
+	// MongoConnectTimeout is how long startup retries until Mongo answers, 0 connects lazily without waiting
+	MongoConnectTimeout time.Duration
+
+	// circuit breaker around Mongo
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// ShardKey is the field single snippet filters also match on (value from X-Shard-Key), empty disables it
+	ShardKey string
+
+	// TLSCert and TLSKey are the certificate and key files, when both are set the server serves HTTPS
+	TLSCert string
+	TLSKey  string
+	// HTTPRedirectAddr (e.g. ":8080") also listens for plain HTTP and redirects it to HTTPS, TLS only
+	HTTPRedirectAddr string
+	// BaseURL (e.g. https://snippets.example.com) is used for the snippet urls instead of the request host
+	BaseURL string
+
+	// Environment is APP_ENV, e.g. development, staging or production
+	Environment string
+	// DebugLogBodies logs request and response bodies of writes (see bodyLogger), refused in production
+	DebugLogBodies    bool
+	DebugLogBodyBytes int
+
+	// ResponseSigningKey is the HMAC secret of ?sign=true (see signResponses), empty disables signing
+	ResponseSigningKey string
+	// CursorSigningKey signs the pagination cursors (see encodeCursor), empty leaves them unsigned
+	CursorSigningKey string
+
+	// admin
+	AdminAPIKey     string
+	AllowAdminReset bool
+	AllowAdminSeed  bool
+	// SeedFile replaces the embedded seed fixtures when set
+	SeedFile string
+	// ModerationRulesFile lists banned patterns (see loadModerationRules), empty disables moderation
+	ModerationRulesFile string
+	ReadOnly            bool
+}
+
+var cfg config
+
+// loadConfig reads the configuration from the environment, falling back to defaults for unset values
+func loadConfig() (config, error) {
+	var c config
+	var err error
+
+	if c.MinNameLen, err = envInt("MIN_NAME_LEN", 1); err != nil {
+		return c, err
+	}
+	if c.MaxNameLen, err = envInt("MAX_NAME_LEN", 100); err != nil {
+		return c, err
+	}
+	if c.MaxCodeBytes, err = envInt("MAX_CODE_BYTES", 64*1024); err != nil {
+		return c, err
+	}
+	if c.MaxTags, err = envInt("MAX_TAGS", 10); err != nil {
+		return c, err
+	}
+	maxBody, err := envInt("MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return c, err
+	}
+	c.MaxBodyBytes = int64(maxBody)
+	if c.CompressThreshold, err = envInt("COMPRESS_THRESHOLD_BYTES", 16*1024); err != nil {
+		return c, err
+	}
+	if c.MaxPageSize, err = envInt("MAX_PAGE_SIZE", 100); err != nil {
+		return c, err
+	}
+	// defaults to the max, which is what a missing ?limit= meant before DEFAULT_PAGE_SIZE existed
+	if c.DefaultPageSize, err = envInt("DEFAULT_PAGE_SIZE", c.MaxPageSize); err != nil {
+		return c, err
+	}
+	if c.PreviewLines, err = envInt("PREVIEW_LINES", 5); err != nil {
+		return c, err
+	}
+	if c.SearchRegexMaxLength, err = envInt("SEARCH_REGEX_MAX_LENGTH", 200); err != nil {
+		return c, err
+	}
+	if c.SearchRegexMaxDepth, err = envInt("SEARCH_REGEX_MAX_DEPTH", 5); err != nil {
+		return c, err
+	}
+	if c.ResponseEnvelope, err = envBool("RESPONSE_ENVELOPE", true); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrent, err = envInt("MAX_CONCURRENT", 100); err != nil {
+		return c, err
+	}
+	if c.MaxWatchers, err = envInt("MAX_WATCHERS", 100); err != nil {
+		return c, err
+	}
+	if c.WarmupConnections, err = envInt("WARMUP_CONNECTIONS", 4); err != nil {
+		return c, err
+	}
+	if c.BreakerThreshold, err = envInt("BREAKER_THRESHOLD", 5); err != nil {
+		return c, err
+	}
+	if c.BreakerCooldown, err = envDuration("BREAKER_COOLDOWN", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.MongoConnectTimeout, err = envDuration("MONGO_CONNECT_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 5*time.Second); err != nil {
+		return c, err
+	}
+	if c.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.ViewFlushInterval, err = envDuration("VIEW_FLUSH_INTERVAL", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.ViewFlushCount, err = envInt("VIEW_FLUSH_COUNT", 1000); err != nil {
+		return c, err
+	}
+	if c.ExpirySweepInterval, err = envDuration("EXPIRY_SWEEP_INTERVAL", time.Hour); err != nil {
+		return c, err
+	}
+	if c.HashBackfillInterval, err = envDuration("HASH_BACKFILL_INTERVAL", 5*time.Minute); err != nil {
+		return c, err
+	}
+	if c.CacheMaxAge, err = envInt("CACHE_MAX_AGE", 60); err != nil {
+		return c, err
+	}
+	c.CacheControlSnippet = strings.TrimSpace(os.Getenv("CACHE_CONTROL_SNIPPET"))
+	if c.CacheControlSnippet == "" {
+		c.CacheControlSnippet = "no-cache"
+		if c.CacheMaxAge > 0 {
+			c.CacheControlSnippet = "max-age=" + strconv.Itoa(c.CacheMaxAge)
+		}
+	}
+	c.CacheControlList = strings.TrimSpace(os.Getenv("CACHE_CONTROL_LIST"))
+	if c.CacheControlList == "" {
+		c.CacheControlList = "no-cache"
+	}
+	if c.SnippetCacheSize, err = envInt("SNIPPET_CACHE_SIZE", 1000); err != nil {
+		return c, err
+	}
+	if c.AllowAdminReset, err = envBool("ALLOW_ADMIN_RESET", false); err != nil {
+		return c, err
+	}
+	if c.AllowAdminSeed, err = envBool("ALLOW_ADMIN_SEED", false); err != nil {
+		return c, err
+	}
+	if c.GenerateSlugs, err = envBool("GENERATE_SLUGS", false); err != nil {
+		return c, err
+	}
+	if c.OwnershipEnabled, err = envBool("SNIPPET_OWNERSHIP", false); err != nil {
+		return c, err
+	}
+	if c.MaxSnippetsPerOwner, err = envInt("MAX_SNIPPETS_PER_OWNER", 0); err != nil {
+		return c, err
+	}
+	if c.ReadOnly, err = envBool("READ_ONLY", false); err != nil {
+		return c, err
+	}
+	if c.DebugLogBodies, err = envBool("DEBUG_LOG_BODIES", false); err != nil {
+		return c, err
+	}
+	if c.DebugLogBodyBytes, err = envInt("DEBUG_LOG_BODY_BYTES", 2048); err != nil {
+		return c, err
+	}
+	c.Environment = strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV")))
+	c.AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+	c.ResponseSigningKey = os.Getenv("RESPONSE_SIGNING_KEY")
+	c.CursorSigningKey = os.Getenv("CURSOR_SIGNING_KEY")
+	c.SeedFile = os.Getenv("SEED_FILE")
+	c.ModerationRulesFile = os.Getenv("MODERATION_RULES_FILE")
+	c.TLSCert = os.Getenv("TLS_CERT")
+	c.TLSKey = os.Getenv("TLS_KEY")
+	c.HTTPRedirectAddr = os.Getenv("HTTP_REDIRECT_ADDR")
+	c.BaseURL = strings.TrimSuffix(strings.TrimSpace(os.Getenv("BASE_URL")), "/")
+	c.ShardKey = strings.TrimSpace(os.Getenv("SHARD_KEY"))
+	c.MongoReadPreference = strings.TrimSpace(os.Getenv("MONGO_READ_PREFERENCE"))
+	if _, err := parseReadPreference(c.MongoReadPreference); err != nil {
+		return c, err
+	}
+	c.MongoWriteConcern = strings.TrimSpace(os.Getenv("MONGO_WRITE_CONCERN"))
+	if _, err := parseWriteConcern(c.MongoWriteConcern); err != nil {
+		return c, err
+	}
+	c.TimestampFormat = strings.ToLower(strings.TrimSpace(os.Getenv("TIMESTAMP_FORMAT")))
+	if c.TimestampFormat == "" {
+		c.TimestampFormat = timestampFormatRFC3339
+	}
+	if c.TimestampFormat != timestampFormatRFC3339 && c.TimestampFormat != timestampFormatUnixMillis {
+		return c, fmt.Errorf("TIMESTAMP_FORMAT must be rfc3339 or unix_ms, got %q", c.TimestampFormat)
+	}
+	c.AllowedLanguages = envList("ALLOWED_LANGUAGES", normalizeLanguage)
+	if c.LanguageExtensions, err = envExtensionMap("LANGUAGE_EXTENSIONS"); err != nil {
+		return c, err
+	}
+	c.RequiredIndexes = envList("REQUIRED_INDEXES", strings.TrimSpace)
+
+	if c.MinNameLen < 1 || c.MinNameLen > c.MaxNameLen {
+		return c, fmt.Errorf("MIN_NAME_LEN (%d) must be between 1 and MAX_NAME_LEN (%d)", c.MinNameLen, c.MaxNameLen)
+	}
+	if c.MaxCodeBytes < 1 {
+		return c, fmt.Errorf("MAX_CODE_BYTES must be positive, got %d", c.MaxCodeBytes)
+	}
+	if c.MaxBodyBytes < int64(c.MaxCodeBytes) {
+		return c, fmt.Errorf("MAX_BODY_BYTES (%d) must be at least MAX_CODE_BYTES (%d)", c.MaxBodyBytes, c.MaxCodeBytes)
+	}
+	if c.ResponseSigningKey != "" && len(c.ResponseSigningKey) < minSigningKeyLen {
+		return c, fmt.Errorf("RESPONSE_SIGNING_KEY must be at least %d bytes, got %d", minSigningKeyLen, len(c.ResponseSigningKey))
+	}
+	if c.CursorSigningKey != "" && len(c.CursorSigningKey) < minSigningKeyLen {
+		return c, fmt.Errorf("CURSOR_SIGNING_KEY must be at least %d bytes, got %d", minSigningKeyLen, len(c.CursorSigningKey))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return c, fmt.Errorf("TLS_CERT and TLS_KEY must be set together")
+	}
+	if c.HTTPRedirectAddr != "" && c.TLSCert == "" {
+		return c, fmt.Errorf("HTTP_REDIRECT_ADDR needs TLS_CERT and TLS_KEY, there is no HTTPS to redirect to")
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return c, fmt.Errorf("BASE_URL must be an absolute http(s) URL, got %q", c.BaseURL)
+		}
+	}
+	if c.DebugLogBodies && c.Environment == "production" {
+		return c, fmt.Errorf("DEBUG_LOG_BODIES must not be enabled when APP_ENV is production")
+	}
+	if c.DebugLogBodyBytes < 1 {
+		return c, fmt.Errorf("DEBUG_LOG_BODY_BYTES must be positive, got %d", c.DebugLogBodyBytes)
+	}
+	if c.MaxPageSize < 1 {
+		return c, fmt.Errorf("MAX_PAGE_SIZE must be positive, got %d", c.MaxPageSize)
+	}
+	if c.DefaultPageSize < 1 || c.DefaultPageSize > c.MaxPageSize {
+		return c, fmt.Errorf("DEFAULT_PAGE_SIZE (%d) must be between 1 and MAX_PAGE_SIZE (%d)", c.DefaultPageSize, c.MaxPageSize)
+	}
+	if c.PreviewLines < 1 {
+		return c, fmt.Errorf("PREVIEW_LINES must be positive, got %d", c.PreviewLines)
+	}
+	if c.SearchRegexMaxLength < 1 {
+		return c, fmt.Errorf("SEARCH_REGEX_MAX_LENGTH must be positive, got %d", c.SearchRegexMaxLength)
+	}
+	if c.SearchRegexMaxDepth < 1 {
+		return c, fmt.Errorf("SEARCH_REGEX_MAX_DEPTH must be positive, got %d", c.SearchRegexMaxDepth)
+	}
+	if c.MaxConcurrent < 0 {
+		return c, fmt.Errorf("MAX_CONCURRENT must not be negative, got %d", c.MaxConcurrent)
+	}
+	if c.ViewFlushInterval <= 0 {
+		return c, fmt.Errorf("VIEW_FLUSH_INTERVAL must be positive, got %s", c.ViewFlushInterval)
+	}
+	if c.ViewFlushCount < 1 {
+		return c, fmt.Errorf("VIEW_FLUSH_COUNT must be positive, got %d", c.ViewFlushCount)
+	}
+	if c.MaxWatchers < 0 {
+		return c, fmt.Errorf("MAX_WATCHERS must not be negative, got %d", c.MaxWatchers)
+	}
+	if c.WarmupConnections < 0 {
+		return c, fmt.Errorf("WARMUP_CONNECTIONS must not be negative, got %d", c.WarmupConnections)
+	}
+	if c.BreakerThreshold < 1 {
+		return c, fmt.Errorf("BREAKER_THRESHOLD must be positive, got %d", c.BreakerThreshold)
+	}
+	if c.CacheMaxAge < 0 {
+		return c, fmt.Errorf("CACHE_MAX_AGE must not be negative, got %d", c.CacheMaxAge)
+	}
+	if strings.ContainsAny(c.CacheControlSnippet+c.CacheControlList, "\r\n") {
+		return c, fmt.Errorf("CACHE_CONTROL_SNIPPET and CACHE_CONTROL_LIST must be single line header values")
+	}
+	if c.SnippetCacheSize < 0 {
+		return c, fmt.Errorf("SNIPPET_CACHE_SIZE must not be negative, got %d", c.SnippetCacheSize)
+	}
+	if c.MaxSnippetsPerOwner < 0 {
+		return c, fmt.Errorf("MAX_SNIPPETS_PER_OWNER must not be negative, got %d", c.MaxSnippetsPerOwner)
+	}
+	if c.MaxTags < 0 {
+		return c, fmt.Errorf("MAX_TAGS must not be negative, got %d", c.MaxTags)
+	}
+
+	return c, nil
+}
+
+// envInt reads an integer environment variable, returning def when it is not set
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", name, v)
+	}
+	return n, nil
+}
+
+// envBool reads a boolean environment variable, returning def when it is not set
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", name, v)
+	}
+	return b, nil
+}
+
+// envDuration reads a duration environment variable such as "30s", returning def when it is not set
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration like 30s, got %q", name, v)
+	}
+	return d, nil
+}
+
+// envList reads a comma separated environment variable, each item is passed through normalize
+// and empty items are skipped. An unset variable gives an empty list.
+func envList(name string, normalize func(string) string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = normalize(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fileExtension is what LANGUAGE_EXTENSIONS accepts as an extension: a dot and a simple suffix
+var fileExtension = regexp.MustCompile(`^\.[A-Za-z0-9_+-]+$`)
+
+// envExtensionMap reads LANGUAGE_EXTENSIONS-style JSON, {"language": ".ext"}. Languages are
+// normalized like on snippets, extensions lowercased. An unset variable gives an empty map.
+func envExtensionMap(name string) (map[string]string, error) {
+	extensions := map[string]string{}
+	v := os.Getenv(name)
+	if v == "" {
+		return extensions, nil
+	}
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		return nil, fmt.Errorf(`%s must be a JSON object like {"elixir": ".ex"}: %s`, name, err)
+	}
+	for language, ext := range raw {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !fileExtension.MatchString(ext) {
+			return nil, fmt.Errorf("%s: %q is not a file extension like .ex", name, ext)
+		}
+		extensions[normalizeLanguage(language)] = ext
+	}
+	return extensions, nil
+}
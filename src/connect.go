@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	// connectPingTimeout bounds one startup ping, the driver would otherwise wait for the whole server selection timeout
+	connectPingTimeout = 2 * time.Second
+	// the delay between startup pings starts at connectRetryInitialDelay and doubles up to connectRetryMaxDelay
+	connectRetryInitialDelay = 500 * time.Millisecond
+	connectRetryMaxDelay     = 5 * time.Second
+)
+
+/*
+connectMongo creates the client and waits until Mongo answers a ping, for at most wait.
+
+	The driver connects lazily, so without the ping a database that isn't up yet (a container
+	started in the same docker-compose) would only show up as failing requests. The ping is
+	retried with a doubling delay until wait has passed, every failed attempt is logged.
+	An error from mongo.Connect itself (e.g. a malformed MONGODB_URI) is returned right away,
+	retrying can't fix it. wait 0 skips the ping and connects lazily as before.
+*/
+func connectMongo(opts *options.ClientOptions, wait time.Duration) (*mongo.Client, error) {
+	c, err := mongo.Connect(context.TODO(), opts)
+	if err != nil || wait <= 0 {
+		return c, err
+	}
+
+	deadline := time.Now().Add(wait)
+	delay := connectRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		timeout := connectPingTimeout
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = c.Ping(ctx, readpref.Primary())
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("connected to mongo after %d attempts\n", attempt)
+			}
+			return c, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			c.Disconnect(context.Background())
+			return nil, fmt.Errorf("mongo still not reachable after %d attempts in %s: %w", attempt, wait, err)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		log.Printf("mongo not reachable (attempt %d), retrying in %s: %s\n", attempt, delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > connectRetryMaxDelay {
+			delay = connectRetryMaxDelay
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// parseReadPreference parses MONGO_READ_PREFERENCE (primary, primaryPreferred, secondary,
+// secondaryPreferred or nearest, case-insensitive), nil for an empty value
+func parseReadPreference(value string) (*readpref.ReadPref, error) {
+	if value == "" {
+		return nil, nil
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("MONGO_READ_PREFERENCE must be one of primary, primaryPreferred, secondary, secondaryPreferred, nearest, got %q", value)
+	}
+	return readpref.New(mode)
+}
+
+// parseWriteConcern parses MONGO_WRITE_CONCERN, "majority" or the number of members that must
+// acknowledge a write (at least 1, unacknowledged writes would hide every error), nil for an
+// empty value
+func parseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(value, "majority") {
+		return writeconcern.Majority(), nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "w"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("MONGO_WRITE_CONCERN must be majority or a positive number of members (e.g. 1 or w1), got %q", value)
+	}
+	return &writeconcern.WriteConcern{W: n}, nil
+}
+
+/*
+applyConsistency sets MONGO_READ_PREFERENCE and MONGO_WRITE_CONCERN on the client options, they
+then apply to every operation. Unset values leave whatever the connection string says.
+
+	Both were validated by loadConfig, so parsing can't fail here.
+*/
+func applyConsistency(opts *options.ClientOptions) *options.ClientOptions {
+	if rp, _ := parseReadPreference(cfg.MongoReadPreference); rp != nil {
+		opts.SetReadPreference(rp)
+	}
+	if wc, _ := parseWriteConcern(cfg.MongoWriteConcern); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return opts
+}
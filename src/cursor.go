@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errInvalidCursor is returned for any cursor that doesn't decode or verify, the reason isn't
+// told to the client so a tampered cursor can't be refined by trial and error
+var errInvalidCursor = errors.New("cursor is invalid, use the next_cursor of the previous page")
+
+// cursorPosition is what a pagination cursor carries: the listing it belongs to (Scope)
+// and the id of the last document of the page, the next page starts after it
+type cursorPosition struct {
+	Scope string `json:"s"`
+	After string `json:"a"`
+}
+
+/*
+encodeCursor returns the opaque next_cursor for a keyset page of scope ending at after.
+
+	The token is the base64url (unpadded) JSON of cursorPosition. With CURSOR_SIGNING_KEY set a
+	"." and the base64url HMAC-SHA256 of that first part follow, so a client can't make up a
+	position. Without a key the cursor is only opaque, decodeCursor still accepts nothing but
+	an id, so no filter can be smuggled in either way.
+*/
+func encodeCursor(scope string, after primitive.ObjectID) string {
+	payload, _ := json.Marshal(cursorPosition{Scope: scope, After: after.Hex()})
+	token := base64.RawURLEncoding.EncodeToString(payload)
+	if cfg.CursorSigningKey != "" {
+		token += "." + base64.RawURLEncoding.EncodeToString(cursorMAC(token))
+	}
+	return token
+}
+
+// decodeCursor returns the position of a cursor made by encodeCursor for the same scope, anything
+// else (malformed, tampered, unsigned while a key is set, another listing's) is errInvalidCursor
+func decodeCursor(scope, token string) (primitive.ObjectID, error) {
+	payload, sig, signed := strings.Cut(token, ".")
+	if cfg.CursorSigningKey != "" {
+		mac, err := base64.RawURLEncoding.DecodeString(sig)
+		if !signed || err != nil || !hmac.Equal(mac, cursorMAC(payload)) {
+			return primitive.NilObjectID, errInvalidCursor
+		}
+	} else if signed {
+		return primitive.NilObjectID, errInvalidCursor
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return primitive.NilObjectID, errInvalidCursor
+	}
+	var pos cursorPosition
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pos); err != nil || pos.Scope != scope {
+		return primitive.NilObjectID, errInvalidCursor
+	}
+	after, err := primitive.ObjectIDFromHex(pos.After)
+	if err != nil {
+		return primitive.NilObjectID, errInvalidCursor
+	}
+	return after, nil
+}
+
+// cursorMAC is the HMAC-SHA256 of the encoded cursor payload under CURSOR_SIGNING_KEY
+func cursorMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(cfg.CursorSigningKey))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testCursorKey = "0123456789abcdef0123456789abcdef"
+
+// withCursorKey sets CURSOR_SIGNING_KEY for one test
+func withCursorKey(t *testing.T, key string) {
+	t.Helper()
+	old := cfg.CursorSigningKey
+	cfg.CursorSigningKey = key
+	t.Cleanup(func() { cfg.CursorSigningKey = old })
+}
+
+// rawCursor encodes a payload the way encodeCursor does, for cursors it would never produce
+func rawCursor(payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload))
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	for _, key := range []string{"", testCursorKey} {
+		withCursorKey(t, key)
+		token := encodeCursor("integrity", id)
+		if signed := strings.Contains(token, "."); signed != (key != "") {
+			t.Errorf("key %q: token %q signed = %v", key, token, signed)
+		}
+		got, err := decodeCursor("integrity", token)
+		if err != nil {
+			t.Fatalf("key %q: decodeCursor(%q) failed: %s", key, token, err)
+		}
+		if got != id {
+			t.Errorf("key %q: decodeCursor = %s, want %s", key, got.Hex(), id.Hex())
+		}
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	withCursorKey(t, testCursorKey)
+	signed := encodeCursor("integrity", id)
+	payload, mac, _ := strings.Cut(signed, ".")
+	flipped := []byte(mac)
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+	otherScope := encodeCursor("other", id)
+
+	withCursorKey(t, "")
+	unsigned := encodeCursor("integrity", id)
+
+	tests := []struct {
+		name  string
+		key   string
+		token string
+	}{
+		{"tampered mac", testCursorKey, payload + "." + string(flipped)},
+		{"tampered payload", testCursorKey, rawCursor(`{"s":"integrity","a":"`+primitive.NewObjectID().Hex()+`"}`) + "." + mac},
+		{"missing mac", testCursorKey, payload},
+		{"unsigned while a key is set", testCursorKey, unsigned},
+		{"signed while no key is set", "", signed},
+		{"mac is not base64", testCursorKey, payload + ".!!!"},
+		{"wrong scope", testCursorKey, otherScope},
+		{"wrong scope unsigned", "", rawCursor(`{"s":"other","a":"` + id.Hex() + `"}`)},
+		{"unknown field", "", rawCursor(`{"s":"integrity","a":"` + id.Hex() + `","filter":{"$ne":null}}`)},
+		{"malformed base64", "", "not base64!"},
+		{"not json", "", rawCursor("integrity:" + id.Hex())},
+		{"malformed object id", "", rawCursor(`{"s":"integrity","a":"zzz"}`)},
+		{"object id as filter", "", rawCursor(`{"s":"integrity","a":{"$gt":""}}`)},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withCursorKey(t, tt.key)
+			if got, err := decodeCursor("integrity", tt.token); err != errInvalidCursor {
+				t.Errorf("decodeCursor(%q) = %s, %v, want errInvalidCursor", tt.token, got.Hex(), err)
+			}
+		})
+	}
+}
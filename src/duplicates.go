@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// codeHash is the hex sha256 of the plain (uncompressed) code, stored as codeHash by setCode
+func codeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// duplicateGroup is one set of snippets with byte for byte the same code
+type duplicateGroup struct {
+	CodeHash string           `bson:"_id" json:"code_hash"`
+	Count    int64            `bson:"count" json:"count"`
+	Snippets []nameSuggestion `bson:"snippets" json:"snippets"`
+}
+
+/*
+getDuplicateSnippets groups snippets by codeHash and returns every group with more than one member.
+
+	Snippets stored before codeHash existed (and snippets whose code was appended to, the
+	append pipeline can't hash inside Mongo and drops the field) are left out until the
+	background job of runHashBackfill has hashed them, the request itself never writes.
+	The biggest groups come first, within a group the oldest snippet comes first.
+	There is no soft delete in this API, a deleted snippet is gone and never part of a group.
+	Burn after reading snippets are left out too, a group would confirm their code without a
+	counted read.
+*/
+func getDuplicateSnippets(w http.ResponseWriter, r *http.Request) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"codeHash": bson.M{"$exists": true}, "maxViews": bson.M{"$exists": false}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$codeHash",
+			"count":    bson.M{"$sum": 1},
+			"snippets": bson.M{"$push": bson.M{"_id": "$_id", "snippetname": "$snippetname"}},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := db.Collection(collectionName).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("failed to find duplicates: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to find duplicates")
+		return
+	}
+
+	groups := []duplicateGroup{}
+	if err := cursor.All(r.Context(), &groups); err != nil {
+		log.Printf("failed to find duplicates: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to find duplicates")
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": groups,
+	})
+}
+
+// hashBackfillBatch is how many snippets one round of backfillCodeHashes reads and hashes,
+// so the code held in memory and the size of one bulk write stay bounded
+const hashBackfillBatch = 200
+
+/*
+runHashBackfill hashes the snippets missing codeHash every interval, until ctx is cancelled.
+
+	The first sweep runs right at startup. Each sweep goes through the missing snippets in _id
+	order, in batches of hashBackfillBatch, so a snippet whose update didn't match (its code
+	changed meanwhile) is left for the next sweep instead of being read again and again.
+	Nothing is written in read-only mode, the sweep waits for the next tick.
+*/
+func runHashBackfill(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !readOnly.Load() {
+			var after primitive.ObjectID
+			for {
+				var n int
+				var err error
+				after, n, err = backfillCodeHashes(ctx, after, hashBackfillBatch)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("failed to hash snippets: %s\n", err)
+					}
+					break
+				}
+				if n < hashBackfillBatch || readOnly.Load() {
+					break
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backfillCodeHashes sets codeHash on up to limit snippets after the id after that don't have one
+// yet, it returns the last id it read and how many. The update only matches while the hash is still
+// missing and the code is unchanged, so a snippet rewritten in the meantime keeps the hash of its new code.
+func backfillCodeHashes(ctx context.Context, after primitive.ObjectID, limit int) (primitive.ObjectID, int, error) {
+	filter := bson.M{"codeHash": bson.M{"$exists": false}, "_id": bson.M{"$gt": after}}
+	opts := options.Find().
+		SetProjection(bson.M{"code": 1, "codeCompressed": 1, "compressed": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+	cursor, err := db.Collection(collectionName).Find(ctx, filter, opts)
+	if err != nil {
+		return after, 0, err
+	}
+
+	missing := []CodeSnippetModel{}
+	if err := cursor.All(ctx, &missing); err != nil {
+		return after, 0, err
+	}
+	if len(missing) == 0 {
+		return after, 0, nil
+	}
+
+	updates := make([]mongo.WriteModel, 0, len(missing))
+	for _, m := range missing {
+		filter := bson.M{"_id": m.ID, "codeHash": bson.M{"$exists": false}}
+		if m.Compressed {
+			filter["codeCompressed"] = m.CodeCompressed
+		} else {
+			filter["code"] = m.Code
+		}
+		update := bson.M{"$set": bson.M{"codeHash": codeHash(m.plainCode())}}
+		updates = append(updates, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
+	}
+	_, err = db.Collection(collectionName).BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+	return missing[len(missing)-1].ID, len(missing), err
+}
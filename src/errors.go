@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+error codes sent in the "code" field of every error response.
+The message next to it is meant for humans and may change wording at any time,
+clients (e.g. frontends translating errors) should switch on the code instead.
+*/
+const (
+	errCodeInvalidBody         = "INVALID_BODY"
+	errCodeInvalidID           = "INVALID_ID"
+	errCodeInvalidQuery        = "INVALID_QUERY"
+	errCodeSnippetNameRequired = "SNIPPET_NAME_REQUIRED"
+	errCodeSnippetNameTooShort = "SNIPPET_NAME_TOO_SHORT"
+	errCodeSnippetNameTooLong  = "SNIPPET_NAME_TOO_LONG"
+	errCodeCodeRequired        = "CODE_REQUIRED"
+	errCodeCodeTooLarge        = "CODE_TOO_LARGE"
+	errCodeTooManyTags         = "TOO_MANY_TAGS"
+	errCodeInvalidTag          = "INVALID_TAG"
+	errCodeLanguageNotAllowed  = "LANGUAGE_NOT_ALLOWED"
+	errCodeBinaryFile          = "BINARY_FILE"
+	errCodeNotFound            = "NOT_FOUND"
+	errCodeConflict            = "CONFLICT"
+	errCodeDuplicateName       = "DUPLICATE_NAME"
+	errCodeInternal            = "INTERNAL_ERROR"
+	errCodeReadOnly            = "READ_ONLY"
+	errCodeDatabaseUnavailable = "DATABASE_UNAVAILABLE"
+	errCodeTooManyRequests     = "TOO_MANY_REQUESTS"
+	errCodeUnauthorized        = "UNAUTHORIZED"
+	errCodeForbidden           = "FORBIDDEN"
+	errCodePreconditionFailed  = "PRECONDITION_FAILED"
+	errCodeQuotaExceeded       = "QUOTA_EXCEEDED"
+	errCodeInvalidTemplate     = "INVALID_TEMPLATE"
+	errCodeLocked              = "LOCKED"
+	errCodeInvalidExpiry       = "INVALID_EXPIRY"
+	errCodeNotAcceptable       = "NOT_ACCEPTABLE"
+	errCodeBodyTooLarge        = "BODY_TOO_LARGE"
+	errCodeAuthorRequired      = "AUTHOR_REQUIRED"
+	errCodeAuthorTooLong       = "AUTHOR_TOO_LONG"
+	errCodeCommentRequired     = "COMMENT_REQUIRED"
+	errCodeCommentTooLong      = "COMMENT_TOO_LONG"
+	errCodeUserIDRequired      = "USER_ID_REQUIRED"
+	errCodeInvalidTimestamp    = "INVALID_TIMESTAMP"
+	errCodeContentRejected     = "CONTENT_REJECTED"
+	errCodeInvalidMaxViews     = "INVALID_MAX_VIEWS"
+	errCodeInvalidFolder       = "INVALID_FOLDER"
+	errCodeUndecodable         = "UNDECODABLE"
+	errCodeNotImplemented      = "NOT_IMPLEMENTED"
+	errCodeRequestTimeout      = "REQUEST_TIMEOUT"
+	errCodeInvalidSource       = "INVALID_SOURCE"
+)
+
+// fieldError describes one input field that failed validation.
+// Limit is the configured constraint that was violated (e.g. the max length), if there is one.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// writeError sends the standard error envelope: {"code": "...", "message": "..."}
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	rnd.JSON(w, status, renderer.M{
+		"code":    code,
+		"message": message,
+	})
+}
+
+// writeValidationError sends a 400 listing every field that failed validation.
+// The top level code/message is the first failure so simple clients can ignore the list.
+func writeValidationError(w http.ResponseWriter, errs []fieldError) {
+	rnd.JSON(w, http.StatusBadRequest, renderer.M{
+		"code":    errs[0].Code,
+		"message": errs[0].Message,
+		"errors":  errs,
+	})
+}
+
+// writeDecodeError answers a request whose body couldn't be decoded: 413 when the body was cut
+// off by the limit of bodyLimit, otherwise 400 with message. err may be nil (e.g. a missing field).
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeBodyTooLarge(w, r, maxErr.Limit)
+		return
+	}
+	writeError(w, http.StatusBadRequest, errCodeInvalidBody, message)
+}
+
+// duplicateKeyCode is the server error code for a write that violates a unique index
+const duplicateKeyCode = 11000
+
+/*
+isDuplicateKey reports whether err is a Mongo duplicate key error, e.g. an insert racing
+another insert of the same name once snippetname has a unique index.
+
+	Depending on the operation the driver reports it as a WriteException (insert/update),
+	a BulkWriteException (insertMany) or a CommandError (findAndModify), all three are checked.
+*/
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		for _, e := range bulkErr.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+		return false
+	}
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == duplicateKeyCode
+	}
+	return false
+}
+
+const (
+	// namespaceNotFoundCode is returned by commands like listIndexes on a collection that doesn't exist
+	namespaceNotFoundCode = 26
+	// namespaceExistsCode is returned by create for a collection that already exists
+	namespaceExistsCode = 48
+	// maxTimeExpiredCode is returned by a query that ran longer than its maxTimeMS
+	maxTimeExpiredCode = 50
+)
+
+// isCommandError reports whether err is a Mongo command error with the given code
+func isCommandError(err error, code int32) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == code
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// expiryReminderWindow is how long before expiresAt a snippet is reported as expiring soon
+const expiryReminderWindow = 24 * time.Hour
+
+/*
+runExpiryReminders sweeps every interval for snippets whose expiresAt falls within the next
+expiryReminderWindow and logs a reminder for each, until ctx is cancelled.
+
+	The TTL index on expiresAt (see ensureIndexes) deletes a snippet once it expires, this gives
+	advance notice. A reminded snippet gets expiryRemindedAt so the next sweeps don't repeat it,
+	changing expiresAt through PUT or PATCH clears the mark again. The conditional update makes
+	sure only one instance logs a reminder when several run the sweep. Sweeps are skipped while
+	the service is read-only, the mark is a write.
+*/
+func runExpiryReminders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !readOnly.Load() {
+			if err := sweepExpiringSnippets(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("expiry reminder sweep failed: %s\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepExpiringSnippets logs one reminder for every snippet expiring soon that wasn't reminded yet
+func sweepExpiringSnippets(ctx context.Context) error {
+	now := time.Now()
+	filter := bson.M{
+		"expiresAt":        bson.M{"$gt": now, "$lte": now.Add(expiryReminderWindow)},
+		"expiryRemindedAt": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetProjection(bson.M{"snippetname": 1, "expiresAt": 1})
+
+	cursor, err := db.Collection(collectionName).Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	expiring := []CodeSnippetModel{}
+	if err := cursor.All(ctx, &expiring); err != nil {
+		return err
+	}
+
+	for _, s := range expiring {
+		claim := bson.M{"_id": s.ID, "expiresAt": s.ExpiresAt, "expiryRemindedAt": bson.M{"$exists": false}}
+		result, err := db.Collection(collectionName).UpdateOne(ctx, claim, bson.M{"$set": bson.M{"expiryRemindedAt": now}})
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 1 {
+			log.Printf("snippet %s (%q) expires at %s and will then be deleted\n",
+				s.ID.Hex(), s.SnippetName, s.ExpiresAt.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
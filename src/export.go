@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+exportSnippets streams snippets as NDJSON, one CodeSnippet JSON object per line.
+
+	?before= only exports snippets created before that moment, given as a date (2023-01-01,
+	midnight UTC) or an RFC 3339 timestamp; anything else is a 400. Like downloadArchive the
+	cursor is written out document by document, so memory stays flat however much is exported,
+	and an error after the first line can only be logged (the client sees a truncated stream).
+*/
+func exportSnippets(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if v := r.URL.Query().Get("before"); v != "" {
+		before, err := parseExportTime(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "before must be a date like 2023-01-01 or an RFC 3339 timestamp")
+			return
+		}
+		filter["createAt"] = bson.M{"$lt": before}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
+	if err != nil {
+		log.Printf("failed to fetch snippets for export: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	w.Header().Set("Content-Disposition", `attachment; filename="code-snippets.ndjson"`)
+	streamNDJSON(w, r, cursor, false)
+}
+
+/*
+streamNDJSON writes every snippet of cursor as one JSON line, or only its id with idsOnly.
+
+	Each line is flushed as soon as it is written so the client can process the snippets while
+	the rest is still being read. Errors after the first line can only be logged, the client
+	sees a truncated stream.
+*/
+func streamNDJSON(w http.ResponseWriter, r *http.Request, cursor *mongo.Cursor, idsOnly bool) {
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	flusher := http.NewResponseController(w)
+
+	// Encode writes a newline after every value, which is exactly the NDJSON framing
+	enc := json.NewEncoder(w)
+	for cursor.Next(r.Context()) {
+		var s CodeSnippetModel
+		if err := cursor.Decode(&s); err != nil {
+			log.Printf("failed to decode snippet for ndjson: %s\n", err)
+			return
+		}
+		var v interface{} = s.ID.Hex()
+		if !idsOnly {
+			v = snippetResponse(r, s)
+		}
+		if err := enc.Encode(v); err != nil {
+			log.Printf("failed to write ndjson: %s\n", err)
+			return
+		}
+		// without a Flusher somewhere in the chain the lines simply go out as the buffer fills
+		flusher.Flush()
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("failed to read snippets for ndjson: %s\n", err)
+	}
+}
+
+// parseExportTime accepts a plain date (taken as midnight UTC) or a full RFC 3339 timestamp
+func parseExportTime(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
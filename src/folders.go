@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxFolderLen is the longest folder path accepted, separators included
+const maxFolderLen = 200
+
+// folderCount is one row of the folders listing, the root folder is ""
+type folderCount struct {
+	Folder string `bson:"_id" json:"folder"`
+	Count  int64  `bson:"count" json:"count"`
+}
+
+/*
+validFolder normalizes a folder path and reports whether it is acceptable.
+
+	Folders are slash separated paths like "utils/http", leading and trailing slashes and the
+	spaces around every segment are dropped, so "/utils/ http/" is "utils/http". Empty segments
+	("utils//http") are refused. The root folder is "" (or "/"), it is the default.
+*/
+func validFolder(folder string) (string, bool) {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if folder == "" {
+		return "", true
+	}
+	segments := strings.Split(folder, "/")
+	for i, segment := range segments {
+		segments[i] = strings.TrimSpace(segment)
+		if segments[i] == "" {
+			return "", false
+		}
+	}
+	folder = strings.Join(segments, "/")
+	return folder, utf8.RuneCountInString(folder) <= maxFolderLen
+}
+
+// folderFilter matches the snippets directly in folder, "" matches the root folder, which
+// includes the snippets stored before folders existed and have no folder field
+func folderFilter(folder string) bson.M {
+	if folder == "" {
+		return bson.M{"folder": bson.M{"$in": bson.A{"", nil}}}
+	}
+	return bson.M{"folder": folder}
+}
+
+/*
+moveSnippet moves a snippet to another folder, POST /id/{codeid}/move?folder=utils/http.
+
+	The folder is set in a single update, ?folder= empty or "/" moves the snippet back to the
+	root. Moving doesn't touch the content, so no version is recorded in the history, but
+	like the other edits it is refused for a locked snippet. PUT and PATCH leave the folder
+	as it is, this is the only way to change it after the create.
+*/
+func moveSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !r.URL.Query().Has("folder") {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "folder is required, use folder=/ for the root folder")
+		return
+	}
+	folder, ok := validFolder(r.URL.Query().Get("folder"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, errCodeInvalidFolder, fmt.Sprintf("a folder must be a path of non-empty segments of at most %d characters", maxFolderLen))
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"folder": folder, "updatedAt": time.Now()}}
+	if folder == "" {
+		update = bson.M{"$set": bson.M{"updatedAt": time.Now()}, "$unset": bson.M{"folder": ""}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err := db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id, "locked": notLocked}), update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to move snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to move snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet moved successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
+
+// getFolders lists the folders in use with the number of snippets directly in each, sorted by
+// path so subfolders follow their parent. The root comes first as "" when it has snippets.
+func getFolders(w http.ResponseWriter, r *http.Request) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"$ifNull": bson.A{"$folder", ""}}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	cursor, err := db.Collection(collectionName).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("failed to list folders: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to list folders")
+		return
+	}
+	folders := []folderCount{}
+	if err := cursor.All(r.Context(), &folders); err != nil {
+		log.Printf("failed to list folders: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to list folders")
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": folders,
+	})
+}
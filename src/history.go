@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyCollectionName holds the earlier versions of every snippet, each one points to its snippet by snippetId
+const historyCollectionName = "code-snippet-history"
+
+type (
+	// historyModel is one earlier version of a snippet as stored in the database
+	historyModel struct {
+		ID          primitive.ObjectID `bson:"_id,omitempty"`
+		SnippetID   primitive.ObjectID `bson:"snippetId"`
+		Version     int                `bson:"version"`
+		SnippetName string             `bson:"snippetname"`
+		Code        string             `bson:"code"`
+		Language    string             `bson:"language,omitempty"`
+		Tags        []string           `bson:"tags,omitempty"`
+		// Reason is the change that replaced this version: "update", "patch", "rename" or "promote"
+		Reason string `bson:"reason"`
+		// PromotedVersion is the version a promote brought back in place of this one
+		PromotedVersion int       `bson:"promotedVersion,omitempty"`
+		SavedAt         time.Time `bson:"savedAt"`
+	}
+	// historyEntry is an earlier version as sent to the client
+	historyEntry struct {
+		Version         int       `json:"version"`
+		SnippetName     string    `json:"snippet_name"`
+		Code            string    `json:"code"`
+		Language        string    `json:"language"`
+		Tags            []string  `json:"tags"`
+		Reason          string    `json:"reason"`
+		PromotedVersion int       `json:"promoted_version,omitempty"`
+		SavedAt         time.Time `json:"saved_at"`
+	}
+)
+
+func toHistoryEntry(m historyModel) historyEntry {
+	return historyEntry{
+		Version:         m.Version,
+		SnippetName:     m.SnippetName,
+		Code:            m.Code,
+		Language:        m.Language,
+		Tags:            m.Tags,
+		Reason:          m.Reason,
+		PromotedVersion: m.PromotedVersion,
+		SavedAt:         m.SavedAt,
+	}
+}
+
+/*
+recordVersion saves the content a snippet had before a change (PUT, PATCH, a rename or a promote).
+
+	Versions are numbered per snippet from 1, the next one is the highest so far plus one. The
+	unique snippetId_1_version_-1 index makes a concurrent change that picked the same number
+	fail, it retries once with the new highest like voteSnippet. Only the content (name, code,
+	language and tags) is kept, not the sort order, lock or score. A failure is logged, the
+	change itself has already been saved. Burn after reading snippets get no history, an
+	earlier version would hand out their code without counting a view.
+*/
+func recordVersion(ctx context.Context, before CodeSnippetModel, reason string, promoted int) {
+	if before.MaxViews > 0 {
+		return
+	}
+	entry := historyModel{
+		SnippetID:       before.ID,
+		SnippetName:     before.SnippetName,
+		Code:            before.plainCode(),
+		Language:        before.Language,
+		Tags:            before.Tags,
+		Reason:          reason,
+		PromotedVersion: promoted,
+		SavedAt:         time.Now(),
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var latest historyModel
+		opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"version": 1})
+		err = db.Collection(historyCollectionName).FindOne(ctx, bson.M{"snippetId": before.ID}, opts).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			break
+		}
+		entry.ID = primitive.NewObjectID()
+		entry.Version = latest.Version + 1
+		if _, err = db.Collection(historyCollectionName).InsertOne(ctx, entry); !isDuplicateKey(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("failed to save version of snippet %s: %s\n", before.ID.Hex(), err)
+	}
+}
+
+// getSnippetHistory lists the earlier versions of a snippet newest first, paginated like getAllSnippets
+func getSnippetHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	var snippet CodeSnippetModel
+	err = db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id}),
+		options.FindOne().SetProjection(bson.M{"maxViews": 1})).Decode(&snippet)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+	cursor, err := db.Collection(historyCollectionName).Find(r.Context(), bson.M{"snippetId": id}, opts)
+	if err != nil {
+		log.Printf("failed to fetch history: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch history")
+		return
+	}
+	found := []historyModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch history: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch history")
+		return
+	}
+
+	versions := []historyEntry{}
+	for _, m := range found {
+		entry := toHistoryEntry(m)
+		// versions recorded before burn snippets were skipped must not leak the code either
+		if snippet.MaxViews > 0 {
+			entry.Code = ""
+		}
+		versions = append(versions, entry)
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       versions,
+		"pagination": page,
+	})
+}
+
+/*
+promoteSnippetVersion makes an earlier version the current content again (POST .../history/{version}/promote).
+
+	Nothing is deleted: the content being replaced is recorded as a new version (reason
+	"promote", with the promoted version number), so a promote can itself be undone by
+	promoting that one. The current document is matched on its updatedAt, a change that lands
+	in between makes the promote fail with 409 instead of being recorded under the wrong
+	content. The promoted content goes through validateSnippet, limits may have changed since.
+*/
+func promoteSnippetVersion(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version < 1 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "the version must be a positive integer")
+		return
+	}
+
+	var current CodeSnippetModel
+	err = db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+	if current.Locked {
+		writeLocked(w)
+		return
+	}
+
+	var old historyModel
+	err = db.Collection(historyCollectionName).FindOne(r.Context(), bson.M{"snippetId": id, "version": version}).Decode(&old)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, fmt.Sprintf("the snippet has no version %d", version))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch version: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch version")
+		return
+	}
+
+	promoted := CodeSnippet{SnippetName: old.SnippetName, Code: old.Code, Language: old.Language, Tags: old.Tags}
+	if errs := validateSnippet(promoted); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+	// the rules may have changed since this version was saved
+	if m := moderateSnippet(promoted.SnippetName, promoted.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	set, unset := codeUpdate(old.Code)
+	set["snippetname"] = old.SnippetName
+	set["language"] = old.Language
+	set["tags"] = old.Tags
+	set["updatedAt"] = time.Now()
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := withShardKey(r, bson.M{"_id": id, "locked": notLocked, "updatedAt": current.UpdatedAt})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusConflict, errCodeConflict, "the snippet was modified concurrently, please retry")
+		return
+	}
+	if isDuplicateKey(err) {
+		writeError(w, http.StatusConflict, errCodeDuplicateName, fmt.Sprintf("a snippet named %q already exists", old.SnippetName))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to promote version: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+	recordVersion(r.Context(), current, "promote", version)
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":          fmt.Sprintf("Version %d promoted successfully", version),
+		"promoted_version": version,
+		"data":             snippetResponse(r, updated),
+	})
+}
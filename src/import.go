@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// importResult is the outcome of one line of an import
+type importResult struct {
+	Line        int    `json:"line"`
+	SnippetName string `json:"snippet_name,omitempty"`
+	// Status is "valid" or "invalid" in a dry run; "created", "invalid" or "failed" (the insert failed) otherwise
+	Status  string       `json:"status"`
+	ID      string       `json:"id,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Errors  []fieldError `json:"errors,omitempty"`
+}
+
+/*
+importSnippets creates snippets from an NDJSON body, one snippet per line (the format of the export).
+
+	Every line is parsed and validated like a create before anything is written, so a body cut
+	off by MAX_BODY_BYTES or a line that can't be read inserts nothing. Lines that fail
+	validation are reported with their line number and skipped, the others are inserted with
+	insertSnippetsUnordered, so one failing insert doesn't stop the rest. ?dry_run=true stops
+	after the validation and inserts nothing, it goes through the same parsing and validation so
+	its verdict matches a real import; only a failure of the insert itself (e.g. a database
+	error) can't be foreseen. ?preserve_timestamps=true keeps the created_at of every line like
+	on create. Blank lines are skipped. With SNIPPET_OWNERSHIP on the valid lines count against
+	MAX_SNIPPETS_PER_OWNER together, see takeBatchOwnership.
+*/
+func importSnippets(w http.ResponseWriter, r *http.Request) {
+	dryRun := queryBool(r, "dry_run")
+	preserve := queryBool(r, "preserve_timestamps")
+	source, ok := requestSource(w, r)
+	if !ok {
+		return
+	}
+
+	results := []importResult{}
+	// pending are the valid lines in order, index is their position in results
+	type pendingInsert struct {
+		index int
+		model CodeSnippetModel
+	}
+	pending := []pendingInsert{}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(cfg.MaxBodyBytes))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		result := importResult{Line: line, Status: "valid"}
+		m, message, errs := prepareSnippetDoc(text, preserve, source)
+		result.SnippetName = m.SnippetName
+		switch {
+		case message != "" || len(errs) > 0:
+			result.Status, result.Message, result.Errors = "invalid", message, errs
+		default:
+			pending = append(pending, pendingInsert{index: len(results), model: m})
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("line %d is longer than %d bytes", line+1, cfg.MaxBodyBytes))
+			return
+		}
+		writeDecodeError(w, r, err, "failed to read the request body")
+		return
+	}
+
+	models := make([]CodeSnippetModel, len(pending))
+	for i, p := range pending {
+		models[i] = p.model
+	}
+	// checked before the dry run returns, so it refuses what the import would refuse
+	if !takeBatchOwnership(w, r, models) {
+		return
+	}
+
+	valid := len(pending)
+	if dryRun {
+		rnd.JSON(w, http.StatusOK, renderer.M{
+			"dry_run": true,
+			"valid":   valid,
+			"invalid": len(results) - valid,
+			"results": results,
+		})
+		return
+	}
+
+	failed, err := insertSnippetsUnordered(r.Context(), models)
+	if err != nil {
+		log.Printf("failed to import snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to import snippets")
+		return
+	}
+
+	created := 0
+	for n, p := range pending {
+		if reason, ok := failed[n]; ok {
+			results[p.index].Status, results[p.index].Message = "failed", reason
+			continue
+		}
+		results[p.index].Status, results[p.index].ID = "created", p.model.ID.Hex()
+		created++
+	}
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"dry_run": false,
+		"created": created,
+		"failed":  len(results) - created,
+		"results": results,
+	})
+}
+
+// prepareSnippetDoc turns one import line (or bulk create item) into the model to insert, the
+// single place the dry run, the real import and the bulk create validate a snippet. It returns
+// either a message (the line isn't a snippet object) or the field errors, both empty when the
+// line is fine.
+func prepareSnippetDoc(line []byte, preserve bool, source string) (CodeSnippetModel, string, []fieldError) {
+	var c CodeSnippet
+	if err := json.Unmarshal(line, &c); err != nil {
+		return CodeSnippetModel{}, "the line is not a valid snippet JSON object", nil
+	}
+	// the source of the whole request, see requestSource
+	c.Source = source
+	if errs := validateSnippet(c); len(errs) > 0 {
+		return CodeSnippetModel{SnippetName: c.SnippetName}, "", errs
+	}
+	if m := moderateSnippet(c.SnippetName, c.Code); m != nil {
+		return CodeSnippetModel{SnippetName: c.SnippetName}, m.message(), nil
+	}
+
+	m := newSnippetModel(c)
+	if preserve {
+		if errs := preserveCreatedAt(c, &m); len(errs) > 0 {
+			return m, "", errs
+		}
+	}
+	return m, "", nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// integrityMaxScan caps the documents one integrity request reads, so a clean collection
+// doesn't have to be read in one go before the first page can be returned
+const integrityMaxScan = 5000
+
+// integrityCursorScope ties the cursors of the integrity scan to it, see decodeCursor
+const integrityCursorScope = "integrity"
+
+// integrityIssue is one stored snippet that fails the current validation
+type integrityIssue struct {
+	ID          string       `json:"id"`
+	SnippetName string       `json:"snippet_name"`
+	Errors      []fieldError `json:"errors"`
+}
+
+/*
+checkIntegrity scans the stored snippets for documents the current validation would reject
+(GET /admin/integrity), e.g. empty names or code saved while the validation was too lax.
+
+	Every document goes through validateSnippet, the same rules as a create, except the expiry
+	being in the future: a stored expiry passes by itself and the TTL index deletes the snippet
+	a little later. The rules read the current configuration, so lowering MAX_CODE_BYTES or
+	restricting ALLOWED_LANGUAGES shows the snippets that no longer fit.
+
+	The scan goes in _id order and is paginated with a cursor instead of an offset: ?limit= is
+	the number of issues per page and ?cursor= the next_cursor of the previous page, an opaque
+	token (see encodeCursor), a malformed or tampered one is a 400. A page also ends after
+	integrityMaxScan documents, so it can have fewer issues than the limit (even none) while
+	next_cursor is still set. next_cursor is null once the end of the collection was reached,
+	also when the page ends on the last document: the scan reads one document past the page
+	to tell, so a client never has to fetch an empty last page.
+*/
+func checkIntegrity(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	filter := bson.M{}
+	if token := strings.TrimSpace(r.URL.Query().Get("cursor")); token != "" {
+		after, err := decodeCursor(integrityCursorScope, token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+			return
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(integrityMaxScan + 1)
+	cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
+	if err != nil {
+		log.Printf("failed to check integrity: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to check integrity")
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	issues := []integrityIssue{}
+	scanned := 0
+	var last primitive.ObjectID
+	for int64(len(issues)) < page.Limit && scanned < integrityMaxScan && cursor.Next(r.Context()) {
+		scanned++
+		last, _ = cursor.Current.Lookup("_id").ObjectIDOK()
+
+		var m CodeSnippetModel
+		if err := cursor.Decode(&m); err != nil {
+			issues = append(issues, integrityIssue{
+				ID:     last.Hex(),
+				Errors: []fieldError{{Code: errCodeUndecodable, Message: err.Error()}},
+			})
+			continue
+		}
+		if errs := storedSnippetErrors(m); len(errs) > 0 {
+			issues = append(issues, integrityIssue{ID: m.ID.Hex(), SnippetName: m.SnippetName, Errors: errs})
+		}
+	}
+	// the scan stopped early (the page is full or the scan cap was hit), there is a next page
+	// only when a document is left after the last one scanned
+	more := scanned > 0 && cursor.Next(r.Context())
+	if err := cursor.Err(); err != nil {
+		log.Printf("failed to check integrity: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to check integrity")
+		return
+	}
+
+	var nextCursor *string
+	if more {
+		next := encodeCursor(integrityCursorScope, last)
+		nextCursor = &next
+	}
+
+	// rnd.JSON rather than respond: without the envelope (?envelope=false) respond would drop
+	// scanned and the cursor, and the scan couldn't get past the first page
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data":    issues,
+		"scanned": scanned,
+		"pagination": renderer.M{
+			"limit":       page.Limit,
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// storedSnippetErrors is validateSnippet for a stored snippet, without the future expiry rule
+func storedSnippetErrors(m CodeSnippetModel) []fieldError {
+	errs := []fieldError{}
+	for _, e := range validateSnippet(toCodeSnippet(m)) {
+		if e.Code != errCodeInvalidExpiry {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
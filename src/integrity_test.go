@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// integrityPage calls GET /admin/integrity and returns the number of issues and the next_cursor
+func integrityPage(t *testing.T, query string) (int, *string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	checkIntegrity(rec, httptest.NewRequest(http.MethodGet, "/integrity?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /integrity?%s = %d %s, want 200", query, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Data       []integrityIssue `json:"data"`
+		Pagination struct {
+			NextCursor *string `json:"next_cursor"`
+		} `json:"pagination"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not an integrity page: %s", rec.Body.String(), err)
+	}
+	return len(body.Data), body.Pagination.NextCursor
+}
+
+// TestIntegrityLastPage checks that a page ending exactly on the last document has no next_cursor
+func TestIntegrityLastPage(t *testing.T) {
+	testDatabase(t)
+	insertInvalid := func(n int) {
+		docs := make([]interface{}, n)
+		for i := range docs {
+			// an empty name and code fail validation, every document is an issue
+			docs[i] = bson.M{"snippetname": "", "code": ""}
+		}
+		if _, err := db.Collection(collectionName).InsertMany(context.Background(), docs); err != nil {
+			t.Fatalf("failed to insert invalid snippets: %s", err)
+		}
+	}
+
+	insertInvalid(2)
+	if n, next := integrityPage(t, "limit=2"); n != 2 || next != nil {
+		t.Errorf("full last page: %d issues, next_cursor %v, want 2 and none", n, next)
+	}
+
+	insertInvalid(1)
+	n, next := integrityPage(t, "limit=2")
+	if n != 2 || next == nil {
+		t.Fatalf("first of two pages: %d issues, next_cursor %v, want 2 and a cursor", n, next)
+	}
+	if n, next := integrityPage(t, "limit=2&cursor="+*next); n != 1 || next != nil {
+		t.Errorf("second page: %d issues, next_cursor %v, want 1 and none", n, next)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// extensionLanguages maps a file extension (lowercase, with the dot) to the language stored on the snippet
+var extensionLanguages = map[string]string{
+	".c":     "c",
+	".h":     "c",
+	".cpp":   "cpp",
+	".cc":    "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".css":   "css",
+	".go":    "go",
+	".html":  "html",
+	".java":  "java",
+	".js":    "javascript",
+	".json":  "json",
+	".kt":    "kotlin",
+	".md":    "markdown",
+	".php":   "php",
+	".py":    "python",
+	".rb":    "ruby",
+	".rs":    "rust",
+	".sh":    "shell",
+	".sql":   "sql",
+	".swift": "swift",
+	".ts":    "typescript",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+}
+
+// languageExtensions is the file extension used when a snippet of that language is written to a file
+var languageExtensions = map[string]string{
+	"c":          ".c",
+	"cpp":        ".cpp",
+	"csharp":     ".cs",
+	"css":        ".css",
+	"go":         ".go",
+	"html":       ".html",
+	"java":       ".java",
+	"javascript": ".js",
+	"json":       ".json",
+	"kotlin":     ".kt",
+	"markdown":   ".md",
+	"php":        ".php",
+	"python":     ".py",
+	"ruby":       ".rb",
+	"rust":       ".rs",
+	"shell":      ".sh",
+	"sql":        ".sql",
+	"swift":      ".swift",
+	"typescript": ".ts",
+	"yaml":       ".yaml",
+}
+
+// extensionFor returns the file extension (with the dot) for a language, ".txt" when it is unknown.
+// LANGUAGE_EXTENSIONS (cfg.LanguageExtensions) is checked first, then the built-in map.
+func extensionFor(language string) string {
+	language = normalizeLanguage(language)
+	if ext, ok := cfg.LanguageExtensions[language]; ok {
+		return ext
+	}
+	if ext, ok := languageExtensions[language]; ok {
+		return ext
+	}
+	return ".txt"
+}
+
+// languageForFilename guesses the language from a file name's extension, "" when it is unknown.
+// The extensions of LANGUAGE_EXTENSIONS are recognized as well, before the built-in ones.
+func languageForFilename(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for language, configured := range cfg.LanguageExtensions {
+		if configured == ext {
+			return language
+		}
+	}
+	return extensionLanguages[ext]
+}
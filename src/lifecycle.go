@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+logLifecycle logs one key=value line for a startup or shutdown step, in the same format as
+the lines of accessLogger so both can be parsed by the same tooling.
+
+	kv alternates keys and values, strings are quoted and everything else is printed with %v,
+	e.g. logLifecycle("server_listening", "port", port, "tls", true) logs
+	event=server_listening port=":9000" tls=true
+*/
+func logLifecycle(event string, kv ...interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event=%s", event)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if s, ok := kv[i+1].(string); ok {
+			fmt.Fprintf(&b, " %v=%q", kv[i], s)
+		} else {
+			fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+		}
+	}
+	log.Println(b.String())
+}
+
+/*
+configLogFields returns the settings worth seeing at startup as key/value pairs for logLifecycle.
+
+	Secrets stay out: the admin key is only reported as set or not, and only the hosts of
+	the Mongo connection string are logged since it may carry a username and password.
+*/
+func configLogFields(c config, mongoURI string) []interface{} {
+	return []interface{}{
+		"environment", c.Environment,
+		"port", port,
+		"tls", tlsEnabled(),
+		"read_only", c.ReadOnly,
+		"mongo_hosts", mongoHosts(mongoURI),
+		"mongo_read_preference", c.MongoReadPreference,
+		"mongo_write_concern", c.MongoWriteConcern,
+		"mongo_connect_timeout", c.MongoConnectTimeout,
+		"max_concurrent", c.MaxConcurrent,
+		"max_watchers", c.MaxWatchers,
+		"max_body_bytes", c.MaxBodyBytes,
+		"max_code_bytes", c.MaxCodeBytes,
+		"default_page_size", c.DefaultPageSize,
+		"max_page_size", c.MaxPageSize,
+		"snippet_cache_size", c.SnippetCacheSize,
+		"shutdown_timeout", c.ShutdownTimeout,
+		"expiry_sweep_interval", c.ExpirySweepInterval,
+		"hash_backfill_interval", c.HashBackfillInterval,
+		"view_flush_interval", c.ViewFlushInterval,
+		"generate_slugs", c.GenerateSlugs,
+		"snippet_ownership", c.OwnershipEnabled,
+		"max_snippets_per_owner", c.MaxSnippetsPerOwner,
+		"admin_api_key_set", c.AdminAPIKey != "",
+		"response_signing", c.ResponseSigningKey != "",
+		"cursor_signing", c.CursorSigningKey != "",
+	}
+}
+
+// mongoHosts returns the host list of a Mongo connection string, without the credentials,
+// database and options. It's cut by hand since url.Parse rejects a list of several hosts.
+func mongoHosts(uri string) string {
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+len("://"):]
+	}
+	if i := strings.IndexAny(uri, "/?"); i >= 0 {
+		uri = uri[:i]
+	}
+	if i := strings.LastIndex(uri, "@"); i >= 0 {
+		uri = uri[i+1:]
+	}
+	return uri
+}
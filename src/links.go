@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+snippetURL is the canonical URL of a snippet, e.g. https://host/code-snippets/id/<hex>.
+
+	BASE_URL wins when it is set, that is the way to get it right behind a proxy that also
+	rewrites the path. Otherwise the scheme and host are the ones the client used: the first
+	X-Forwarded-Proto / X-Forwarded-Host value when a proxy sets them, else the request's own.
+*/
+func snippetURL(r *http.Request, id string) string {
+	base := cfg.BaseURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		host := r.Host
+		if forwarded := firstHeaderValue(r, "X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+		base = scheme + "://" + host
+	}
+	return base + "/code-snippets/id/" + id
+}
+
+// firstHeaderValue returns the first entry of a comma separated header, proxies append theirs to the list
+func firstHeaderValue(r *http.Request, name string) string {
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// snippetResponse converts a stored snippet for a response, with its canonical url. The code of
+// a burn after reading snippet is left out, only GET /id/{codeid} serves it (and counts the view).
+// With ?preview=true the code is replaced by its first lines, see codePreview.
+func snippetResponse(r *http.Request, m CodeSnippetModel) CodeSnippet {
+	s := toCodeSnippet(m)
+	s.URL = snippetURL(r, s.ID)
+	s.timestampFormat = timestampFormat(r)
+	if m.MaxViews > 0 {
+		s.Code = ""
+	} else if queryBool(r, "preview") {
+		preview := codePreview(s.Code, cfg.PreviewLines)
+		s.Preview = &preview
+		s.Code = ""
+	}
+	return s
+}
+
+// snippetPreview is the start of a snippet's code for list views
+type snippetPreview struct {
+	Code string `json:"code"`
+	// HasMore is true when the code has more lines than the preview shows
+	HasMore bool `json:"has_more"`
+}
+
+// codePreview returns the first n lines of code. Line endings come back as \n even when the code
+// uses CRLF, trailing blank lines don't count, so code ending in a newline isn't "more".
+func codePreview(code string, n int) snippetPreview {
+	lines := codeLines(strings.TrimRight(code, "\r\n"))
+	if len(lines) <= n {
+		return snippetPreview{Code: strings.Join(lines, "\n")}
+	}
+	return snippetPreview{Code: strings.Join(lines[:n], "\n"), HasMore: true}
+}
+
+const (
+	// timestampFormatRFC3339 writes timestamps as RFC 3339 strings, the default
+	timestampFormatRFC3339 = "rfc3339"
+	// timestampFormatUnixMillis writes them as milliseconds since the Unix epoch
+	timestampFormatUnixMillis = "unix_ms"
+)
+
+// timestampFormat is the timestamp format of a response: ?timestamp_format=rfc3339|unix_ms, or
+// TIMESTAMP_FORMAT when the query doesn't name one of the two. Only snippets honor it.
+func timestampFormat(r *http.Request) string {
+	switch f := r.URL.Query().Get("timestamp_format"); f {
+	case timestampFormatRFC3339, timestampFormatUnixMillis:
+		return f
+	}
+	return cfg.TimestampFormat
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// codeContainsMinLen is the shortest ?code_contains= accepted, shorter strings match nearly everything
+const codeContainsMinLen = 3
+
+/*
+snippetListFilter builds the Mongo filter from the list filters in the query string.
+
+	?code_contains= only matches snippets whose code contains the text literally, case-sensitive
+	unless ?ci=true. No index can serve a substring match, Mongo scans every document, which
+	the X-Search-Mode: scan header points out. Code stored compressed is not searched, neither
+	is the code of burn after reading snippets: probing it would reveal the code without a
+	counted read, so they never match.
+
+	?empty_code=true only matches snippets without code (empty or missing, e.g. written before
+	validation required it), ?empty_code=false only those with code. Compressed snippets
+	always have code, their plain code field is just empty.
+
+	?language= and ?tag= only match snippets of that language or carrying that tag.
+	?folder= only matches the snippets directly in that folder (not in its subfolders),
+	?folder=/ (or an empty value) the ones in the root folder.
+	?source= only matches snippets created by that client (see requestSource), ?source=unknown
+	also matches the ones stored before the source was recorded.
+
+	?min_bytes= and ?max_bytes= (both inclusive) only match snippets whose uncompressed code
+	size is in the range. The stored codeBytes is used when it exists (the codeBytes_1 index
+	serves it), older snippets without it are measured with $strLenBytes like in the stats.
+
+	Archived snippets are not left out here, the callers add withoutArchived themselves so an
+	empty filter still means the client sent no filter at all (see batchUpdateSnippets).
+*/
+func snippetListFilter(w http.ResponseWriter, r *http.Request) (bson.M, error) {
+	var conditions bson.A
+
+	if language := normalizeLanguage(r.URL.Query().Get("language")); language != "" {
+		conditions = append(conditions, bson.M{"language": language})
+	}
+	if tag := strings.TrimSpace(r.URL.Query().Get("tag")); tag != "" {
+		conditions = append(conditions, bson.M{"tags": tag})
+	}
+
+	if r.URL.Query().Has("folder") {
+		folder, ok := validFolder(r.URL.Query().Get("folder"))
+		if !ok {
+			return nil, fmt.Errorf("folder is not a valid folder path")
+		}
+		conditions = append(conditions, folderFilter(folder))
+	}
+
+	if r.URL.Query().Has("source") {
+		source, ok := validSource(r.URL.Query().Get("source"))
+		if !ok {
+			return nil, fmt.Errorf("source must be at most %d letters, digits, '.', '_' or '-'", maxSourceLen)
+		}
+		conditions = append(conditions, sourceFilter(source))
+	}
+
+	if sizeRange, err := codeSizeFilter(r); err != nil {
+		return nil, err
+	} else if sizeRange != nil {
+		conditions = append(conditions, sizeRange)
+	}
+
+	if contains := r.URL.Query().Get("code_contains"); contains != "" {
+		if utf8.RuneCountInString(contains) < codeContainsMinLen {
+			return nil, fmt.Errorf("code_contains must be at least %d characters", codeContainsMinLen)
+		}
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(contains)}
+		if queryBool(r, "ci") {
+			pattern.Options = "i"
+		}
+		conditions = append(conditions, bson.M{"code": pattern, "maxViews": bson.M{"$exists": false}})
+		w.Header().Set("X-Search-Mode", "scan")
+	}
+
+	if v := r.URL.Query().Get("empty_code"); v != "" {
+		empty, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("empty_code must be true or false")
+		}
+		// {"$in": ["", null]} matches an empty string as well as a missing field
+		if empty {
+			conditions = append(conditions, bson.M{"code": bson.M{"$in": bson.A{"", nil}}, "compressed": bson.M{"$ne": true}})
+		} else {
+			conditions = append(conditions, bson.M{"$or": bson.A{
+				bson.M{"code": bson.M{"$nin": bson.A{"", nil}}},
+				bson.M{"compressed": true},
+			}})
+		}
+	}
+
+	var filter bson.M
+	switch len(conditions) {
+	case 0:
+		filter = bson.M{}
+	case 1:
+		filter = conditions[0].(bson.M)
+	default:
+		filter = bson.M{"$and": conditions}
+	}
+	return filter, nil
+}
+
+// codeSizeFilter is the ?min_bytes=&max_bytes= condition of snippetListFilter, nil without either
+func codeSizeFilter(r *http.Request) (bson.M, error) {
+	bounds := bson.M{}
+	var min, max int64 = 0, -1
+	for _, name := range []string{"min_bytes", "max_bytes"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative integer, got %q", name, v)
+		}
+		if name == "min_bytes" {
+			min, bounds["$gte"] = n, n
+		} else {
+			max, bounds["$lte"] = n, n
+		}
+	}
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+	if max >= 0 && min > max {
+		return nil, fmt.Errorf("min_bytes (%d) must not be greater than max_bytes (%d)", min, max)
+	}
+
+	measured := bson.A{}
+	if v, ok := bounds["$gte"]; ok {
+		measured = append(measured, bson.M{"$gte": bson.A{codeBytesExpr, v}})
+	}
+	if v, ok := bounds["$lte"]; ok {
+		measured = append(measured, bson.M{"$lte": bson.A{codeBytesExpr, v}})
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"codeBytes": bounds},
+		bson.M{"codeBytes": bson.M{"$exists": false}, "$expr": bson.M{"$and": measured}},
+	}}, nil
+}
+
+// countSnippets returns the number of snippets matching the same filters as the list endpoint
+func countSnippets(w http.ResponseWriter, r *http.Request) {
+	filter, err := snippetListFilter(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+	filter = withoutArchived(r, filter)
+
+	count, err := db.Collection(collectionName).CountDocuments(r.Context(), filter)
+	if err != nil {
+		log.Printf("failed to count snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to count snippets")
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": renderer.M{"count": count},
+	})
+}
+
+// idsOnlyProjection is the projection of ?ids_only=true, only the _id leaves the database
+var idsOnlyProjection = bson.M{"_id": 1}
+
+/*
+wantIDsOnly reports whether the list or search response should be just the ids (?ids_only=true).
+
+	The data is then an array of id strings instead of snippets, the pagination is unchanged.
+	There is no ?fields= selection, but ids_only is already the narrowest one, so a request
+	with both is rejected instead of one of them being silently ignored.
+*/
+func wantIDsOnly(r *http.Request) (bool, error) {
+	if !queryBool(r, "ids_only") {
+		return false, nil
+	}
+	if r.URL.Query().Has("fields") {
+		return false, fmt.Errorf("ids_only and fields can't be combined")
+	}
+	return true, nil
+}
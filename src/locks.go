@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notLocked is added to the filter of every write that changes or deletes a snippet, a locked
+// snippet then simply doesn't match and the handler reports 423 (see snippetLocked)
+var notLocked = bson.M{"$ne": true}
+
+// errSnippetLocked means the write was refused because the snippet is locked
+var errSnippetLocked = errors.New("snippet is locked")
+
+// snippetLocked tells a write that matched nothing apart: the snippet is locked (true, nil),
+// or it doesn't exist (false, mongo.ErrNoDocuments)
+func snippetLocked(r *http.Request, id primitive.ObjectID) (bool, error) {
+	var s CodeSnippetModel
+	opts := options.FindOne().SetProjection(bson.M{"locked": 1})
+	if err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id}), opts).Decode(&s); err != nil {
+		return false, err
+	}
+	return s.Locked, nil
+}
+
+// writeLocked sends the 423 response for a write to a locked snippet
+func writeLocked(w http.ResponseWriter) {
+	writeError(w, http.StatusLocked, errCodeLocked, "the snippet is locked, unlock it first")
+}
+
+// lockSnippet marks a snippet immutable: updates, appends, tag changes and deletes get 423 until it is unlocked
+func lockSnippet(w http.ResponseWriter, r *http.Request) {
+	setSnippetLocked(w, r, true)
+}
+
+// unlockSnippet makes a locked snippet editable again
+func unlockSnippet(w http.ResponseWriter, r *http.Request) {
+	setSnippetLocked(w, r, false)
+}
+
+// setSnippetLocked is lockSnippet and unlockSnippet, both are routed behind requireAPIKey
+func setSnippetLocked(w http.ResponseWriter, r *http.Request, locked bool) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"locked": locked, "updatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err := db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id}), update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update snippet lock: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
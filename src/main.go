@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -17,6 +22,7 @@ import (
 	"github.com/thedevsaddam/renderer"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 
 	//"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -40,11 +46,19 @@ var db *mongo.Database
 // mongo client var
 var client *mongo.Client
 
+// flushes and stops the tracer provider, set up in init()
+var shutdownTracing func(context.Context) error
+
 const (
+	serviceName    string = "go-snippet-api"
+	serviceVersion string = "1.0.0"
 	collectionName string = "code-snippets"
 	port           string = ":9000"
 )
 
+// snippetNameCollation compares snippet names ignoring case (strength 2 = base letters + accents)
+var snippetNameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type (
 	/*
 	 the tags are used to provide additional information about how the struct fields should be serialized or deserialized when
@@ -59,19 +73,208 @@ type (
 	CodeSnippetModel struct {
 		ID          primitive.ObjectID `bson:"_id,omitempty"`
 		CreatedAt   time.Time          `bson:"createAt"`
+		UpdatedAt   *time.Time         `bson:"updatedAt,omitempty"`
 		SnippetName string             `bson:"snippetname"`
 		Code        string             `bson:"code"`
+		// large code is stored gzip compressed in CodeCompressed instead of Code, see setCode
+		CodeCompressed []byte `bson:"codeCompressed,omitempty"`
+		Compressed     bool   `bson:"compressed,omitempty"`
+		CodeBytes      int    `bson:"codeBytes,omitempty"`
+		// CodeHash is the sha256 of the plain code, used to find exact duplicates
+		CodeHash  string   `bson:"codeHash,omitempty"`
+		Language  string   `bson:"language,omitempty"`
+		Tags      []string `bson:"tags,omitempty"`
+		SortOrder int      `bson:"sortOrder"`
+		// Owner is the X-User-ID that created the snippet (SNIPPET_OWNERSHIP), see takeOwnership
+		Owner string `bson:"owner,omitempty"`
+		// Folder is the slash separated folder path, missing or "" is the root, see folders.go
+		Folder string `bson:"folder,omitempty"`
+		// Source is the client that created the snippet, missing means unknown, see requestSource
+		Source string `bson:"source,omitempty"`
+		// Score is the sum of all votes, see votes.go
+		Score int `bson:"score,omitempty"`
+		// ShareCount is the number of times the snippet was fetched through the share endpoint
+		ShareCount int `bson:"shareCount,omitempty"`
+		// ViewCount is the number of reads, written in batches by viewCounts
+		ViewCount int `bson:"viewCount,omitempty"`
+		// Slug is the URL friendly name generated on create (GENERATE_SLUGS), it stays when the snippet is renamed
+		Slug string `bson:"slug,omitempty"`
+		// MaxViews > 0 deletes the snippet once it was read that many times, Views counts the reads, see burn.go
+		MaxViews int `bson:"maxViews,omitempty"`
+		Views    int `bson:"views,omitempty"`
+		// Locked snippets refuse every edit and delete until they are unlocked, see locks.go
+		Locked bool `bson:"locked,omitempty"`
+		// Archived snippets are left out of the default listings, see archived.go
+		Archived   bool       `bson:"archived,omitempty"`
+		ArchivedAt *time.Time `bson:"archivedAt,omitempty"`
+		// LastAccessedAt is refreshed (at most once per accessThrottle) when the snippet is read
+		LastAccessedAt *time.Time `bson:"lastAccessedAt,omitempty"`
+		// ExpiresAt is optional, the TTL index deletes the snippet once it has passed
+		ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
 	}
 	//this is the response json type which will be sent to the client when retrived from database or from client (req.body) to be stored in db
 	// All fields must start with Capital letters
 	CodeSnippet struct {
-		ID          string    `json:"id"`
-		SnippetName string    `json:"snippetname"`
-		Code        string    `json:"code"`
-		CreatedAt   time.Time `json:"created_at"`
+		ID          string   `json:"id"`
+		SnippetName string   `json:"snippet_name"`
+		Code        string   `json:"code"`
+		Language    string   `json:"language"`
+		Tags        []string `json:"tags"`
+		SortOrder   int      `json:"sort_order"`
+		Owner       string   `json:"owner,omitempty"`
+		// Folder is taken on create, moveSnippet changes it afterwards
+		Folder     string `json:"folder"`
+		Locked     bool   `json:"locked"`
+		Archived   bool   `json:"archived"`
+		Score      int    `json:"score"`
+		ShareCount int    `json:"share_count"`
+		ViewCount  int    `json:"view_count"`
+		// MaxViews is only taken on create, the snippet can't be turned into a burn snippet later
+		MaxViews int `json:"max_views,omitempty"`
+		// ExpectedUpdatedAt is only read by PUT, see updateSnippet
+		ExpectedUpdatedAt *time.Time `json:"expected_updated_at,omitempty"`
+		LastAccessedAt    *time.Time `json:"last_accessed_at,omitempty"`
+		ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+		CreatedAt         time.Time  `json:"created_at"`
+		UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+		// Preview replaces Code in responses to ?preview=true, see snippetResponse
+		Preview *snippetPreview `json:"preview,omitempty"`
+		// Slug is set by the server, a slug sent by a client is ignored
+		Slug string `json:"slug,omitempty"`
+		// Source is set from the request on create (see requestSource), a source in the body is ignored
+		Source string `json:"source"`
+		// URL is the canonical link of the snippet, only set in responses (see snippetResponse)
+		URL string `json:"url,omitempty"`
+
+		// requestedCreatedAt is the created_at a client sent, only used by ?preserve_timestamps=true
+		requestedCreatedAt json.RawMessage
+		// timestampFormat is how MarshalJSON writes the timestamps, set by snippetResponse
+		timestampFormat string
 	}
 )
 
+/*
+UnmarshalJSON decodes a CodeSnippet from the request body.
+
+	The name used to be sent as "snippetname", it is "snippet_name" now. Older clients still
+	send the old key, so it is accepted as a fallback when "snippet_name" is missing.
+	Responses only ever use "snippet_name".
+*/
+func (c *CodeSnippet) UnmarshalJSON(data []byte) error {
+	// the alias type has the same fields but not this method, so decoding into it doesn't recurse
+	type codeSnippetAlias CodeSnippet
+	var v struct {
+		codeSnippetAlias
+		LegacySnippetName string `json:"snippetname"`
+		// id and created_at are set by the server, these shadow the alias fields so whatever a
+		// client sends there (even a number instead of a string) is skipped instead of failing the decode
+		ID        json.RawMessage `json:"id"`
+		CreatedAt json.RawMessage `json:"created_at"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*c = CodeSnippet(v.codeSnippetAlias)
+	if c.SnippetName == "" {
+		c.SnippetName = v.LegacySnippetName
+	}
+	c.requestedCreatedAt = v.CreatedAt
+	return nil
+}
+
+/*
+MarshalJSON encodes a CodeSnippet for a response.
+
+	Timestamps are RFC 3339 strings unless the snippet was prepared with timestampFormatUnixMillis
+	(see snippetResponse), then they are milliseconds since the Unix epoch. The numbers shadow
+	the time fields of the alias the same way UnmarshalJSON shadows the legacy name.
+*/
+func (c CodeSnippet) MarshalJSON() ([]byte, error) {
+	type codeSnippetAlias CodeSnippet
+	if c.timestampFormat != timestampFormatUnixMillis {
+		return json.Marshal(codeSnippetAlias(c))
+	}
+	return json.Marshal(struct {
+		codeSnippetAlias
+		LastAccessedAt *int64 `json:"last_accessed_at,omitempty"`
+		ExpiresAt      *int64 `json:"expires_at,omitempty"`
+		CreatedAt      int64  `json:"created_at"`
+		UpdatedAt      *int64 `json:"updated_at,omitempty"`
+	}{
+		codeSnippetAlias: codeSnippetAlias(c),
+		LastAccessedAt:   unixMillis(c.LastAccessedAt),
+		ExpiresAt:        unixMillis(c.ExpiresAt),
+		CreatedAt:        c.CreatedAt.UnixMilli(),
+		UpdatedAt:        unixMillis(c.UpdatedAt),
+	})
+}
+
+// marshalSnippetWith encodes a response type that embeds CodeSnippet: the snippet (through its
+// MarshalJSON) followed by the fields of extra, which replace snippet fields of the same name
+// (the relevance "score" of a search result hides the vote score, as embedding always did).
+// Embedding promotes CodeSnippet.MarshalJSON, which on its own would leave those fields out,
+// so such types call this from their own MarshalJSON.
+func marshalSnippetWith(c CodeSnippet, extra interface{}) ([]byte, error) {
+	snippet, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := json.Marshal(extra)
+	if err != nil {
+		return nil, err
+	}
+	var replaced map[string]json.RawMessage
+	if err := json.Unmarshal(fields, &replaced); err != nil {
+		return nil, err
+	}
+
+	// copy the snippet's fields in their order, skipping the replaced ones
+	var out bytes.Buffer
+	out.WriteByte('{')
+	dec := json.NewDecoder(bytes.NewReader(snippet))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if _, ok := replaced[key.(string)]; ok {
+			continue
+		}
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		out.Write(name)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	if len(fields) > 2 {
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		out.Write(fields[1 : len(fields)-1])
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// unixMillis is t in milliseconds since the Unix epoch, nil stays nil
+func unixMillis(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	ms := t.UnixMilli()
+	return &ms
+}
+
 // the init func is used for initializing the global var to be used outside the main func
 
 //REGARDING context.TODO()
@@ -101,20 +304,51 @@ func init() {
 		log.Println("No .env file found")
 	}
 
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	if moderationRules, err = loadModerationRules(cfg.ModerationRulesFile); err != nil {
+		log.Fatalf("failed to load the moderation rules: %s", err)
+	}
+
+	if cfg.ReadOnly {
+		setReadOnly(true)
+	}
+	dbBreaker = newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	if cfg.MaxConcurrent > 0 {
+		concurrencySlots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	snippetCache = newSnippetLRU(cfg.SnippetCacheSize)
+}
+
+/*
+connectDatabase sets up tracing and connects to Mongo, main calls it before anything uses db.
+
+	It isn't part of init so the tests of the package can run without a database (or with
+	their own, see the tests that need one).
+*/
+func connectDatabase() {
 	uri := os.Getenv("MONGODB_URI")
 	if uri == "" {
 		log.Fatal("You must set your 'MONGODB_URI' environmental variable. See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable")
 	}
 
-	// err type of error
 	var err error
-	client, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
-	if err != nil {
-		panic(err)
+	if shutdownTracing, err = setupTracing(context.Background()); err != nil {
+		log.Fatalf("failed to set up tracing: %s", err)
 	}
-	if err == nil {
-		fmt.Printf("mongodb isrunning now")
+	logLifecycle("config_loaded", configLogFields(cfg, uri)...)
+
+	// the otelmongo monitor creates a span for every Mongo command, as a child of the request span
+	// MONGO_READ_PREFERENCE and MONGO_WRITE_CONCERN override the connection string, see applyConsistency
+	// MONGO_CONNECT_TIMEOUT is how long startup waits for Mongo to come up, see connectMongo
+	client, err = connectMongo(applyConsistency(options.Client().ApplyURI(uri).SetMonitor(otelmongo.NewMonitor())), cfg.MongoConnectTimeout)
+	if err != nil {
+		log.Fatalf("failed to connect to mongo: %s", err)
 	}
+	// with MONGO_CONNECT_TIMEOUT=0 the client connects lazily, nothing has been pinged yet
+	logLifecycle("db_connected", "hosts", mongoHosts(uri), "verified", cfg.MongoConnectTimeout > 0)
 
 	db = client.Database("Code-Snippet-Manager") // Replace with your actual database name
 }
@@ -123,47 +357,94 @@ func createSnippet(w http.ResponseWriter, r *http.Request) {
 	//creating an instance of Codesnippet json struct
 	var c CodeSnippet
 
-	//we are going to be decoding the json recieved from the frontend to a struct type
+	//we are going to be decoding the json recieved from the frontend to a struct type,
+	// in the schema version the client asked for (see apiversion.go)
 
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-		// remember rnd pckg is used to send json response and it collects 3 values
-		// the writer intance, status code, message to be sent , the mssg is a map data structure
-		// here we are sending the err
-		rnd.JSON(w, http.StatusProcessing, err)
+	if !decodeSnippetBody(w, r, &c) {
 		// we are returning from the function since we are getting an err while decoding
 		//so theres no need to conti ue the execution of the function
 		return
 	}
+	var ok bool
+	if c.Source, ok = requestSource(w, r); !ok {
+		return
+	}
+
+	// ?trim=true normalizes trailing whitespace before storing, without it the code is stored as sent
+	if queryBool(r, "trim") {
+		c.Code = trimCode(c.Code)
+	}
 
 	// validating input
 
-	if c.Code == "" && c.SnippetName == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message1": "the code input field is requested",
-			"message2": "the snippet Name field is requested",
-		})
+	if errs := validateSnippet(c); len(errs) > 0 {
+		writeValidationError(w, errs)
 		// return from func no need to continue execution of func
 		return
 	}
+	if m := moderateSnippet(c.SnippetName, c.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
 
 	// if input is okay
 	// create/insert into database
 	// converting into a bson data to be inputted into the mongodb database because only bson
 	//is supported with mongodb
-	cm := CodeSnippetModel{
-		ID:          primitive.NewObjectID(),
-		CreatedAt:   time.Now(),
-		Code:        c.Code,
-		SnippetName: c.SnippetName,
+	cm := newSnippetModel(c)
+	if !takeOwnership(w, r, &cm) {
+		return
 	}
 
-	// storing the data into the database
-	result, err := db.Collection(collectionName).InsertOne(context.TODO(), &cm)
-	if err != nil {
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to save Code Snippet",
-			"error":   err,
+	// the id and creation time are server-authoritative, a client supplied id or created_at is
+	// ignored. Imports can keep the original created_at with ?preserve_timestamps=true.
+	if queryBool(r, "preserve_timestamps") {
+		if errs := preserveCreatedAt(c, &cm); len(errs) > 0 {
+			writeValidationError(w, errs)
+			return
+		}
+	}
+
+	// If-None-Match: * means "only create it if no snippet with this name exists yet"
+	if r.Header.Get("If-None-Match") == "*" {
+		var created bool
+		err := insertNewSnippet(r.Context(), &cm, func() (err error) {
+			created, err = insertSnippetIfAbsent(r.Context(), cm)
+			return err
 		})
+		if isDuplicateKey(err) {
+			// a concurrent create of the same name won the race on the unique index
+			created, err = false, nil
+		}
+		if err != nil {
+			log.Printf("failed to save snippet: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save Code Snippet")
+			return
+		}
+		if !created {
+			writeError(w, http.StatusPreconditionFailed, errCodePreconditionFailed,
+				fmt.Sprintf("a snippet named %q already exists", cm.SnippetName))
+			return
+		}
+
+		rnd.JSON(w, http.StatusCreated, createdResponse(cm, cm.ID))
+		return
+	}
+
+	// storing the data into the database, with a slug of the name when GENERATE_SLUGS is on
+	var result *mongo.InsertOneResult
+	err := insertNewSnippet(r.Context(), &cm, func() (err error) {
+		result, err = db.Collection(collectionName).InsertOne(r.Context(), &cm)
+		return err
+	})
+	if isDuplicateKey(err) {
+		// free names the client can retry with, e.g. "name-2"
+		writeNameTaken(w, r, cm.SnippetName)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save Code Snippet")
 		return
 	}
 
@@ -171,12 +452,74 @@ func createSnippet(w http.ResponseWriter, r *http.Request) {
 
 	// returning the inserted id  as json response
 
-	rnd.JSON(w, http.StatusCreated, renderer.M{
+	rnd.JSON(w, http.StatusCreated, createdResponse(cm, result.InsertedID))
+
+}
+
+// createdResponse is the body of a successful create, the slug and owner are only there when set
+func createdResponse(cm CodeSnippetModel, id interface{}) renderer.M {
+	body := renderer.M{
 		"message": "Snippet created successfully",
 		//"snippet_id": cm.ID.Hex(),
-		"snippet_id": result.InsertedID,
-	})
+		"snippet_id": id,
+	}
+	if cm.Slug != "" {
+		body["slug"] = cm.Slug
+	}
+	if cm.Owner != "" {
+		body["owner"] = cm.Owner
+	}
+	return body
+}
 
+/*
+insertSnippetIfAbsent inserts the snippet only when no snippet with the same name exists.
+
+	Instead of looking the name up first and inserting afterwards (two requests could both see
+	"absent" and both insert), this is a single upsert keyed on the name whose document is only
+	written through $setOnInsert. If a snippet with the name already exists nothing changes and
+	UpsertedCount is 0. Concurrent upserts of a brand new name can still both insert unless the
+	name has a unique index, in that case the loser gets a duplicate key error instead.
+*/
+func insertSnippetIfAbsent(ctx context.Context, cm CodeSnippetModel) (bool, error) {
+	filter := bson.M{"snippetname": cm.SnippetName}
+	update := bson.M{"$setOnInsert": cm}
+
+	result, err := db.Collection(collectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount == 1, nil
+}
+
+/*
+checkSnippet runs the same validation as createSnippet (including ?trim=true) without saving anything.
+
+	It answers 200 {"valid": true} for a snippet that would be accepted, otherwise the same
+	400 response createSnippet would send, listing every field error.
+*/
+func checkSnippet(w http.ResponseWriter, r *http.Request) {
+	var c CodeSnippet
+	if !decodeSnippetBody(w, r, &c) {
+		return
+	}
+
+	if queryBool(r, "trim") {
+		c.Code = trimCode(c.Code)
+	}
+
+	if errs := validateSnippet(c); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+	if m := moderateSnippet(c.SnippetName, c.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"valid": true,
+	})
 }
 
 func getSnippet(w http.ResponseWriter, r *http.Request) {
@@ -185,89 +528,288 @@ func getSnippet(w http.ResponseWriter, r *http.Request) {
 	snippetName := chi.URLParam(r, "snippetName")
 
 	// Create a filter to find the snippet by its name
-	filter := bson.M{"snippetname": snippetName}
+	filter := withShardKey(r, bson.M{"snippetname": snippetName})
 
 	// Create a variable to hold the result of the find operation the bson snippet model
 	var foundSnippet CodeSnippetModel
 
-	// decoding the snippet into a bson data, codeSnippetmodel because the findone will return a bson data
-	if err := db.Collection(collectionName).FindOne(context.TODO(), filter).Decode(&foundSnippet); err != nil {
-		rnd.JSON(w, http.StatusNotFound, renderer.M{
-			"message": "Snippet not found",
-			"error":   err,
-		})
+	if queryBool(r, "ci") {
+		/*
+		 case-insensitive lookup uses a collation with strength 2 (compare letters, ignore case).
+		 Mongo only uses an index for a collated query when the index was built with the same
+		 collation, which is why ensureIndexes creates the snippetname_ci index below.
+		 Names are not unique, so "MySnippet" and "mysnippet" may both match; we fetch at most
+		 two (newest first) to return the most recent one and flag the ambiguity in a header.
+		*/
+		opts := options.Find().
+			SetCollation(snippetNameCollation).
+			SetSort(bson.D{{Key: "createAt", Value: -1}}).
+			SetLimit(2)
+
+		cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
+		if err != nil {
+			log.Printf("failed to fetch snippet: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+			return
+		}
+
+		matches := []CodeSnippetModel{}
+		if err := cursor.All(r.Context(), &matches); err != nil {
+			log.Printf("failed to fetch snippet: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+			return
+		}
+
+		if len(matches) == 0 {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+			return
+		}
+		if len(matches) > 1 {
+			w.Header().Set("X-Snippet-Ambiguous", "true")
+		}
+		foundSnippet = matches[0]
+	} else if err := db.Collection(collectionName).FindOne(r.Context(), filter).Decode(&foundSnippet); err != nil {
+		// decoding the snippet into a bson data, codeSnippetmodel because the findone will return a bson data
+		if err != mongo.ErrNoDocuments {
+			log.Printf("failed to fetch snippet: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
 		return
 	}
 
-	// we are storing the found bson data into the codesnippet struct json data structure
-	codesnippets := CodeSnippet{
-		ID:          foundSnippet.ID.Hex(),
-		CreatedAt:   foundSnippet.CreatedAt,
-		Code:        foundSnippet.Code,
-		SnippetName: foundSnippet.SnippetName,
+	touchSnippet(foundSnippet)
+
+	if notModified(w, r, foundSnippet.lastModified()) {
+		return
 	}
 
+	// we are storing the found bson data into the codesnippet struct json data structure
+	codesnippets := snippetResponse(r, foundSnippet)
+
 	// sending the struct data to the frontend
-	rnd.JSON(w, http.StatusOK, renderer.M{
+	respond(w, r, http.StatusOK, renderer.M{
 		"data": codesnippets,
 	})
 
 }
 
+/*
+getSnippetByID returns one snippet by its id.
+
+	?format=code sends only the code as text/plain, byte for byte as stored (nothing added or
+	trimmed), so `curl .../id/<id>?format=code | pbcopy` copies exactly the snippet. GET
+	/id/<id>/raw is the same. The code is served with http.ServeContent, which honors Range
+	requests: a satisfiable range gets 206 with just those bytes, an unsatisfiable one 416,
+	and no Range header the full code with 200. Accept-Ranges: bytes advertises it.
+	Hot snippets are served from snippetCache, the X-Cache header tells whether this one was.
+	A snippet created with max_views is never cached, every read goes through consumeView.
+*/
+func getSnippetByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" && format != "code" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "format must be one of: json, yaml, code")
+		return
+	}
+
+	foundSnippet, epoch, hit := snippetCache.get(id)
+	if !hit {
+		err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&foundSnippet)
+		if err == mongo.ErrNoDocuments {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+			return
+		}
+		if err != nil {
+			log.Printf("failed to fetch snippet: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+			return
+		}
+		// a cached copy could be served without counting the view
+		if foundSnippet.MaxViews == 0 {
+			snippetCache.put(foundSnippet, epoch)
+		}
+	}
+	if snippetCache != nil {
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	touchSnippet(foundSnippet)
+
+	burn := foundSnippet.MaxViews > 0
+	if burn {
+		if foundSnippet, ok = consumeView(w, r, foundSnippet); !ok {
+			return
+		}
+	} else if notModified(w, r, foundSnippet.lastModified()) {
+		return
+	}
+
+	if format == "code" {
+		// setting the type up front keeps ServeContent from sniffing it
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Accept-Ranges", "bytes")
+		// inline keeps browsers showing the code, "save as" then suggests <name><ext> like the zip archive
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+			"filename": archiveFileBase(foundSnippet.SnippetName) + extensionFor(foundSnippet.Language),
+		}))
+		http.ServeContent(w, r, "", foundSnippet.lastModified(), strings.NewReader(foundSnippet.plainCode()))
+		return
+	}
+
+	data := snippetResponse(r, foundSnippet)
+	if burn {
+		// this is the read that counted, the only one that gets the code
+		data.Code = foundSnippet.plainCode()
+	}
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": data,
+	})
+}
+
+// getRawSnippet is getSnippetByID with ?format=code, for clients that want a plain URL to the code
+func getRawSnippet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	q.Set("format", "code")
+	r.URL.RawQuery = q.Encode()
+	getSnippetByID(w, r)
+}
+
+/*
+getAllSnippets lists snippets one page at a time (?limit=&offset=), see parsePagination for how
+the limit is clamped. The response carries the limit and offset that were actually used.
+The list can be narrowed with the filters of snippetListFilter, GET /count takes the same ones.
+Archived snippets are left out unless ?include_archived=true, see withoutArchived.
+?preview=true sends the first PREVIEW_LINES lines of every snippet instead of its code, see codePreview.
+?ids_only=true returns only the ids, see wantIDsOnly.
+With Accept: application/x-ndjson the list is streamed one snippet per line (see streamNDJSON)
+with the same filters and sort. It isn't held to the page size then, every match is sent
+unless ?limit= is given, ?offset= still applies.
+*/
 func getAllSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	filter, err := snippetListFilter(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+	filter = withoutArchived(r, filter)
+
+	idsOnly, err := wantIDsOnly(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
 	// var to hold the res of all bson data found in the database to a slice since its multiple dats
 	snippets := []CodeSnippetModel{}
 
+	// sorting on _id keeps the insertion order and makes the pages stable
+	sort := bson.D{{Key: "_id", Value: 1}}
+	switch r.URL.Query().Get("sort") {
+	case "":
+	case "pinned":
+		// highest sort order first (pinned to the top), then newest first.
+		// Documents stored before sortOrder existed have no field and sort after every number.
+		sort = bson.D{{Key: "sortOrder", Value: -1}, {Key: "createAt", Value: -1}, {Key: "_id", Value: 1}}
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "sort must be one of: pinned")
+		return
+	}
+
+	ndjson := wantNDJSON(r)
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(page.Offset)
+	if !ndjson || r.URL.Query().Get("limit") != "" {
+		opts.SetLimit(page.Limit)
+	}
+	if idsOnly {
+		opts.SetProjection(idsOnlyProjection)
+	}
+
 	// The Find method returns a cursor to the query results and an error
-	cursor, err := db.Collection(collectionName).Find(context.TODO(), bson.M{})
+	cursor, err := db.Collection(collectionName).Find(r.Context(), filter, opts)
 	if err != nil {
 		//panic(err)
-		rnd.JSON(w, http.StatusNotFound, renderer.M{
-			"error": err,
-		})
+		log.Printf("failed to fetch snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	if ndjson {
+		defer cursor.Close(r.Context())
+		streamNDJSON(w, r, cursor, idsOnly)
 		return
 	}
 
 	//  retrieve all documents from the cursor using the All method.
-	if err = cursor.All(context.TODO(), &snippets); err != nil {
+	if err = cursor.All(r.Context(), &snippets); err != nil {
 		//panic(err)
-		rnd.JSON(w, http.StatusNotFound, renderer.M{
-			"message": "failed to fetch snippets",
-			"error":   err,
+		log.Printf("failed to fetch snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	if idsOnly {
+		ids := []string{}
+		for _, s := range snippets {
+			ids = append(ids, s.ID.Hex())
+		}
+		respond(w, r, http.StatusOK, renderer.M{
+			"data":       ids,
+			"pagination": page,
 		})
 		return
 	}
+
 	// codeSnippet Struct json to be sent to the frontend
 	snippetsList := []CodeSnippet{}
 	// looping through the snippets slice bson struct to be converted to the json slice of struct
 	for _, s := range snippets {
-		snippetsList = append(snippetsList, CodeSnippet{
-			ID:          s.ID.Hex(),
-			SnippetName: s.SnippetName,
-			CreatedAt:   s.CreatedAt,
-			Code:        s.Code,
-		})
+		snippetsList = append(snippetsList, snippetResponse(r, s))
 	}
 
 	// sending the struct slice of json to the frontend
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"data": snippetsList,
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       snippetsList,
+		"pagination": page,
 	})
 
 }
 
+/*
+updateSnippet replaces a snippet (PUT). Every failure has its own status:
+
+	400 INVALID_ID for an id that isn't an ObjectID, 400 INVALID_BODY when the body doesn't
+	decode (406/413 for an unsupported schema version or an oversized body), 400 with the
+	field errors when validation fails, 404 when no snippet has the id, 423 when it is
+	locked, 409 when the new name is taken and 500 only for database errors.
+	The replaced content is kept as a version in the history, see recordVersion.
+
+	expected_updated_at in the body makes the update conditional (optimistic concurrency): it
+	only applies while the stored snippet was last modified at exactly that time (its
+	updated_at, or created_at if it was never updated), otherwise nothing is written and the
+	response is 409 CONFLICT. The check is part of the update filter, so two clients sending
+	the same expected_updated_at can't both win.
+*/
 func updateSnippet(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("update function getting started")
-	// getting the id of the snippet code that wants to updated
-	idstr := strings.TrimSpace(chi.URLParam(r, "codeid"))
-	fmt.Printf("update function getting started")
-	//  convert the received id to a MongoDB ObjectID using primitive.ObjectIDFromHex(id).
-	id, err := primitive.ObjectIDFromHex(idstr)
-	if err != nil {
-		// If the conversion fails (invalid ID), send a JSON response
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The id is invalid",
-		})
+	// getting the id of the snippet code that wants to updated,
+	// snippetIDParam sends the 400 itself when it isn't a valid ObjectID
+	id, ok := snippetIDParam(w, r)
+	if !ok {
 		return
 	}
 
@@ -275,54 +817,271 @@ func updateSnippet(w http.ResponseWriter, r *http.Request) {
 	var s CodeSnippet
 
 	// decoding the json data recived to a json struct type
-	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
-		rnd.JSON(w, http.StatusProcessing, err)
+	if !decodeSnippetBody(w, r, &s) {
 		return
 	}
 
+	if queryBool(r, "trim") {
+		s.Code = trimCode(s.Code)
+	}
+
 	// validating input
 
-	if s.Code == "" && s.SnippetName == "" {
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message1": "the code input field is requested",
-			"message2": "the snippet Name field is requested",
-		})
+	if errs := validateSnippet(s); len(errs) > 0 {
+		writeValidationError(w, errs)
 		// return from func no need to continue execution of func
 		return
 	}
+	if m := moderateSnippet(s.SnippetName, s.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
 
 	// The filter is specifying that you want to match documents with
 	// a specific _id field value. The id variable is used as the value for the _id field.
-	filter := bson.D{{Key: "_id", Value: id}}
+	filter := withShardKey(r, bson.M{"_id": id, "locked": notLocked})
+	if s.ExpectedUpdatedAt != nil {
+		filter["$or"] = bson.A{
+			bson.M{"updatedAt": *s.ExpectedUpdatedAt},
+			bson.M{"updatedAt": bson.M{"$exists": false}, "createAt": *s.ExpectedUpdatedAt},
+		}
+	}
 
 	/*
 	   This line creates an update document using the bson.D type.
 	    The update is using the $set operator to modify the value of a field. It specifies that you want to update the
 	   the following
 	*/
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "snippetname", Value: s.SnippetName}, {Key: "code", Value: s.Code}}}}
+	set, unset := codeUpdate(s.Code)
+	set["snippetname"] = s.SnippetName
+	set["language"] = normalizeLanguage(s.Language)
+	set["tags"] = s.Tags
+	set["updatedAt"] = time.Now()
+	if unset == nil {
+		unset = bson.M{}
+	}
+	// PUT replaces the snippet, leaving expires_at out removes the expiry
+	if s.ExpiresAt != nil {
+		set["expiresAt"] = *s.ExpiresAt
+	} else {
+		unset["expiresAt"] = ""
+	}
+	unset["expiryRemindedAt"] = ""
+	update := bson.M{"$set": set, "$unset": unset}
+
+	// the current content goes to the history once the update succeeded. A missing snippet is
+	// reported by the update below, the read only fails the request on a database error.
+	var before CodeSnippetModel
+	err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&before)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+
+	// options.After makes FindOneAndUpdate hand back the document as it is after the update,
+	// so the client sees the saved state without a second request
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	result, err := db.Collection(collectionName).UpdateOne(context.TODO(), filter, update)
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
 	if err != nil {
-		// panic(err)
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to update ",
-			"error":   err,
+		writeUpdateFailure(w, err, s.SnippetName, s.ExpectedUpdatedAt != nil, func() (bool, error) {
+			return snippetLocked(r, id)
 		})
 		return
 	}
 
-	// When you run this file for the first time, it should print:
-	// Number of documents replaced: 1
-	fmt.Printf("Documents updated: %v\n", result.ModifiedCount)
+	recordVersion(r.Context(), before, "update", 0)
+
+	fmt.Printf("Document updated: %s\n", updated.ID.Hex())
 
 	// returning data to the frontend
 	rnd.JSON(w, http.StatusOK, renderer.M{
 		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
 	})
 
 }
 
+/*
+writeUpdateFailure answers a PUT whose FindOneAndUpdate failed with err, see updateSnippet.
+
+	FindOneAndUpdate reports a filter that matched nothing (the MatchedCount == 0 case) as
+	ErrNoDocuments: locked (only asked then) tells a locked snippet (423) from a missing one
+	(404), and for a conditional update an existing snippet means expected_updated_at no longer
+	matched (409 CONFLICT). A duplicate name is a 409 DUPLICATE_NAME, anything else a 500.
+*/
+func writeUpdateFailure(w http.ResponseWriter, err error, name string, conditional bool, locked func() (bool, error)) {
+	if err == mongo.ErrNoDocuments {
+		isLocked, lerr := locked()
+		if isLocked {
+			writeLocked(w)
+			return
+		}
+		if lerr == nil && conditional {
+			writeError(w, http.StatusConflict, errCodeConflict, "the snippet was modified since expected_updated_at, fetch it again and retry")
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if isDuplicateKey(err) {
+		writeError(w, http.StatusConflict, errCodeDuplicateName, fmt.Sprintf("a snippet named %q already exists", name))
+		return
+	}
+	log.Printf("failed to update snippet: %s\n", err)
+	writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+}
+
+/*
+appendSnippetCode appends text to the end of a snippet's code, separated by a newline.
+
+	The append happens inside a single update using an aggregation pipeline ($concat), so two
+	clients appending at the same time never lose each other's text. The size limit is part of
+	the filter, so a document that would grow past MAX_CODE_BYTES simply doesn't match and is
+	left untouched. Compressed code can't be concatenated inside Mongo, those snippets go through
+	appendCompressedCode. Uncompressed code that grows past the compression threshold here stays
+	uncompressed until the next full update.
+*/
+func appendSnippetCode(w http.ResponseWriter, r *http.Request) {
+	idstr := strings.TrimSpace(chi.URLParam(r, "codeid"))
+	id, err := primitive.ObjectIDFromHex(idstr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidID, "The id is invalid")
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, "invalid request body")
+		return
+	}
+	if body.Code == "" {
+		writeError(w, http.StatusBadRequest, errCodeCodeRequired, "the code input field is requested")
+		return
+	}
+	if m := moderateSnippet("", body.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	// empty code gets the text as is, otherwise code + "\n" + text
+	appended := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$code", ""}}, ""}},
+		body.Code,
+		bson.M{"$concat": bson.A{"$code", "\n", body.Code}},
+	}}
+
+	filter := withShardKey(r, bson.M{
+		"_id":        id,
+		"compressed": bson.M{"$ne": true},
+		"locked":     notLocked,
+		"$expr":      bson.M{"$lte": bson.A{bson.M{"$strLenBytes": appended}, cfg.MaxCodeBytes}},
+	})
+	// sha256 can't be computed in a pipeline, the stale codeHash is dropped and backfilled by runHashBackfill
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"code":      appended,
+			"codeBytes": bson.M{"$strLenBytes": appended},
+			"updatedAt": time.Now(),
+		}}},
+		{{Key: "$unset", Value: "codeHash"}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		// the snippet doesn't exist, appending would overflow or the code is compressed, find out which
+		var current CodeSnippetModel
+		err = db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&current)
+		if err == nil {
+			if current.Locked {
+				err = errSnippetLocked
+			} else if !current.Compressed {
+				err = errAppendTooLarge
+			} else {
+				updated, err = appendCompressedCode(r.Context(), current, body.Code)
+			}
+		}
+	}
+	snippetCache.invalidate(id)
+	if err == errSnippetLocked {
+		writeLocked(w)
+		return
+	}
+	if err == errAppendTooLarge {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+			fmt.Sprintf("appending would exceed the maximum code size of %d bytes", cfg.MaxCodeBytes))
+		return
+	}
+	if err == errAppendConflict {
+		writeError(w, http.StatusConflict, errCodeConflict, "the snippet was modified concurrently, please retry")
+		return
+	}
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to append to snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to append to snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
+
+// setSnippetSortOrder sets the manual sort order used by ?sort=pinned, body: {"sort_order": 10}
+func setSnippetSortOrder(w http.ResponseWriter, r *http.Request) {
+	idstr := strings.TrimSpace(chi.URLParam(r, "codeid"))
+	id, err := primitive.ObjectIDFromHex(idstr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidID, "The id is invalid")
+		return
+	}
+
+	var body struct {
+		SortOrder *int `json:"sort_order"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.SortOrder == nil {
+		writeDecodeError(w, r, err, `the request body must be {"sort_order": <integer>}`)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"sortOrder": *body.SortOrder, "updatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id, "locked": notLocked}), update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to set sort order: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
+
 func deleteSnippet(w http.ResponseWriter, r *http.Request) {
 	// getting the id of the snippet code that wants to deleted
 	idstr := strings.TrimSpace(chi.URLParam(r, "id"))
@@ -331,36 +1090,51 @@ func deleteSnippet(w http.ResponseWriter, r *http.Request) {
 	id, err := primitive.ObjectIDFromHex(idstr)
 	if err != nil {
 		// If the conversion fails (invalid ID), send a JSON response
-		rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The id is invalid",
-		})
+		writeError(w, http.StatusBadRequest, errCodeInvalidID, "The id is invalid")
 		return
 	}
 	// id to be deleted
-	filter := bson.D{{Key: "_id", Value: id}}
-
-	result, err := db.Collection(collectionName).DeleteOne(context.TODO(), filter)
+	filter := withShardKey(r, bson.M{"_id": id, "locked": notLocked})
+
+	// FindOneAndDelete removes the document and hands it back in one atomic step,
+	// so the response shows exactly what was deleted
+	var deleted CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndDelete(r.Context(), filter).Decode(&deleted)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
 	if err != nil {
-
-		rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to delete snippet",
-			"error":   err,
-		})
+		log.Printf("failed to delete snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to delete snippet")
 		return
 
 	}
 
-	// When you run this file for the first time, it should print:
-	// Documents deleted: 1
-	fmt.Printf("Documents deleted: %d\n", result.DeletedCount)
+	fmt.Printf("Document deleted: %s\n", deleted.ID.Hex())
+	deleteSnippetDependents(r.Context(), bson.M{"snippetId": deleted.ID})
 
+	data := toCodeSnippet(deleted)
+	// like snippetResponse, deleting a burn after reading snippet doesn't reveal its code
+	if deleted.MaxViews > 0 {
+		data.Code = ""
+	}
 	rnd.JSON(w, http.StatusOK, renderer.M{
-		"message": "Code Snippet deleted successfully",
+		"message":    "Code Snippet deleted successfully",
+		"snippet_id": deleted.ID.Hex(),
+		"deleted_at": time.Now().UTC(),
+		"data":       data,
 	})
 
 }
 
 func main() {
+	connectDatabase()
 
 	/*
 
@@ -374,6 +1148,8 @@ func main() {
 		if err := client.Disconnect(context.TODO()); err != nil {
 			panic(err)
 		}
+		logLifecycle("db_disconnected")
+		logLifecycle("stopped")
 	}()
 
 	/*
@@ -381,21 +1157,68 @@ func main() {
 	   it when an interrupt signal (e.g., Ctrl+C) is received. This is used to gracefully shut down the server.
 	*/
 
-	stopChan := make(chan os.Signal)
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt)
 
+	// background jobs stop when background is cancelled on shutdown
+	background, stopBackground := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+
 	/*
 	   Creates a new chi router and attaches a logger middleware to it to log all requests.
 	   Then it registers a handler for the root URL path ("/") using the GET method, which is the homeHandler function.
 	*/
 
+	ensureCollections()
+	logLifecycle("indexes_ensured", "failed", ensureIndexes())
+	warmupPool(cfg.WarmupConnections)
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		runViewFlusher(background, cfg.ViewFlushInterval)
+	}()
+
+	if cfg.ExpirySweepInterval > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			runExpiryReminders(background, cfg.ExpirySweepInterval)
+		}()
+	}
+
+	// hashes the snippets missing codeHash for /duplicates, see runHashBackfill
+	if cfg.HashBackfillInterval > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			runHashBackfill(background, cfg.HashBackfillInterval)
+		}()
+	}
+
 	r := chi.NewRouter()
-	// log all requests
-	r.Use(middleware.Logger)
-	//r.Get("/", homeHandler)
+	// log all requests, including the response size, see accesslog.go
+	r.Use(accessLogger)
+	// one span per request, see tracing.go
+	r.Use(tracingMiddleware)
+	// shed load with 429 once MAX_CONCURRENT requests are in flight
+	r.Use(concurrencyLimiter)
+	// cap request bodies at MAX_BODY_BYTES before any handler reads them
+	r.Use(bodyLimit)
+	// bodies of writes end up in the log, off unless DEBUG_LOG_BODIES=true outside production
+	if cfg.DebugLogBodies {
+		r.Use(bodyLogger)
+	}
+	// HEAD is served by the GET routes and OPTIONS lists the methods of a path, the mounted
+	// routers repeat both since the routes they match are only known to them
+	r.Use(middleware.GetHead, optionsResponder(r))
+	r.Get("/", homeHandler(r))
+	r.Get("/status", statusHandler)
+	r.Get("/readyz", readyHandler)
 
 	// Mounts the subrouter returned by the todoHandlers() function under the "/todo" URL path.
 	r.Mount("/code-snippets", snippetsHandlers())
+	r.Mount("/admin", adminHandlers())
 
 	/*
 		Creates an instance of http.Server with various settings,
@@ -408,31 +1231,98 @@ func main() {
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Shutdown doesn't wait for the hijacked WebSocket connections, they are told to close
+	srv.RegisterOnShutdown(stopWatchers)
 
 	/*
 		This starts a new goroutine (using go func() { ... }()) to listen and serve incoming HTTP requests.
 		 It logs the start of the server and handles any errors that might occur during the server's execution.
 	*/
+	// with TLS_CERT and TLS_KEY set the server speaks HTTPS, otherwise plain HTTP
+	var redirectSrv *http.Server
 	go func() {
-		log.Println("Listening on port ", port)
-		if err := srv.ListenAndServe(); err != nil {
+		var err error
+		if tlsEnabled() {
+			logLifecycle("server_listening", "port", port, "tls", true)
+			err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			logLifecycle("server_listening", "port", port, "tls", false)
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
 			log.Printf("listen: %s\n", err)
 		}
 	}()
+	if tlsEnabled() && cfg.HTTPRedirectAddr != "" {
+		redirectSrv = startRedirectServer(cfg.HTTPRedirectAddr)
+	}
 
 	/*
 	   (<-stopChan) waits for a signal to be received on stopChan, which happens when the interrupt signal is triggered (e.g., Ctrl+C).
-	    When the signal is received, it triggers a graceful shutdown process. It creates a context with a timeout of 5 seconds,
-	   attempts to gracefully shut down the server using srv.Shutdown(ctx), and logs the successful server shutdown.
+	    When the signal is received, it triggers a graceful shutdown process. It creates a context with a timeout of SHUTDOWN_TIMEOUT
+	   (5 seconds by default), attempts to gracefully shut down the server using srv.Shutdown(ctx), and logs the successful server shutdown.
+	   Requests still running when the timeout expires are cut off by srv.Close().
 
 	*/
 
-	<-stopChan
-	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	srv.Shutdown(ctx)
+	sig := <-stopChan
+	logLifecycle("signal_received", "signal", sig.String())
+	stopBackground()
+	jobs.Wait()
+	logLifecycle("draining", "in_flight", inFlight.Load(), "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	log.Println("Server gracefully stopped!")
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("warning: requests still in flight after %s (%d left), closing connections: %s\n", cfg.ShutdownTimeout, inFlight.Load(), err)
+		srv.Close()
+	}
+	// the flusher already stopped, this writes the views of the requests that were draining
+	flushViewCounts()
+	// the drain may have used up ctx, flushing the last spans gets its own deadline
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("failed to flush traces: %s\n", err)
+	}
+	// db_disconnected and stopped are logged by the deferred disconnect above
+}
+
+/*
+homeHandler serves the root URL path with the service name, version and every registered endpoint.
+
+	The endpoint list is built by walking the router, so it stays in sync with the routes
+	automatically.
+*/
+func homeHandler(routes chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type endpoint struct {
+			Method string `json:"method"`
+			Path   string `json:"path"`
+		}
+		endpoints := []endpoint{}
+		walk := func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			endpoints = append(endpoints, endpoint{Method: method, Path: strings.TrimSuffix(route, "/*")})
+			return nil
+		}
+		if err := chi.Walk(routes, walk); err != nil {
+			log.Printf("failed to list routes: %s\n", err)
+		}
+		sort.Slice(endpoints, func(i, j int) bool {
+			if endpoints[i].Path != endpoints[j].Path {
+				return endpoints[i].Path < endpoints[j].Path
+			}
+			return endpoints[i].Method < endpoints[j].Method
+		})
+
+		respond(w, r, http.StatusOK, renderer.M{
+			"service":   serviceName,
+			"version":   serviceVersion,
+			"endpoints": endpoints,
+		})
+	}
 }
 
 /*
@@ -444,16 +1334,310 @@ and maps each HTTP method to its corresponding handler function.
 */
 func snippetsHandlers() http.Handler {
 	rg := chi.NewRouter()
+	rg.Use(middleware.GetHead, optionsResponder(rg))
+	// only validates, it never touches the database so read-only mode and the breaker don't apply
+	rg.With(requestTimeout(cfg.RequestTimeout)).Post("/check", checkSnippet)
+	// derived from the validation config, see snippetSchema
+	rg.Get("/schema", getSnippetSchema)
 	rg.Group(func(r chi.Router) {
-		r.Get("/", getAllSnippets)
-		r.Get("/{snippetName}", getSnippet)
-		r.Post("/", createSnippet)
-		r.Put("/{codeid}", updateSnippet)
-		r.Delete("/{id}", deleteSnippet)
+		r.Use(readOnlyGuard)
+		r.Use(breakerMiddleware)
+		// streaming endpoints run as long as the data takes, they get no REQUEST_TIMEOUT deadline
+		r.Get("/archive", downloadArchive)
+		r.Get("/export", exportSnippets)
+		r.Post("/import", importSnippets)
+		r.Get("/id/{codeid}/ws", watchSnippet)
+		r.Group(func(r chi.Router) {
+			r.Use(requestTimeout(cfg.RequestTimeout))
+			// ?sign=true adds an HMAC of the body, see signResponses
+			r.Use(signResponses)
+			// lists and aggregates change with every write, they get CACHE_CONTROL_LIST. Single
+			// snippets set CACHE_CONTROL_SNIPPET themselves, see notModified.
+			r.Group(func(r chi.Router) {
+				r.Use(cacheControl(cfg.CacheControlList))
+				r.Get("/", getAllSnippets)
+				r.Get("/stats", getSnippetStats)
+				r.Get("/top", getTopSnippets)
+				r.Get("/most-shared", getMostSharedSnippets)
+				r.Get("/tags", getTagFacet)
+				r.Get("/folders", getFolders)
+				r.Get("/archived", getArchivedSnippets)
+				r.Get("/count", countSnippets)
+				r.Get("/autocomplete", autocompleteSnippetNames)
+				r.Get("/stale", getStaleSnippets)
+				r.Get("/duplicates", getDuplicateSnippets)
+				r.Get("/search", searchSnippets)
+				r.Get("/id/{codeid}/related", getRelatedSnippets)
+				r.Get("/id/{codeid}/comments", getSnippetComments)
+				r.Get("/id/{codeid}/history", getSnippetHistory)
+			})
+			r.Get("/{snippetName}", getSnippet)
+			r.Get("/slug/{slug}", getSnippetBySlug)
+			r.Post("/", createSnippet)
+			r.Post("/upload", uploadSnippet)
+			r.Post("/batch", batchGetSnippets)
+			r.Post("/batch-by-name", batchGetSnippetsByName)
+			r.Post("/bulk", bulkCreateSnippets)
+			r.Post("/bulk-delete", bulkDeleteSnippets)
+			r.Put("/{codeid}", updateSnippet)
+			r.Patch("/", batchUpdateSnippets)
+			r.Patch("/{codeid}", patchSnippet)
+			r.Delete("/{id}", deleteSnippet)
+			r.Get("/id/{codeid}", getSnippetByID)
+			r.Get("/id/{codeid}/render", renderSnippet)
+			r.Post("/id/{codeid}/append", appendSnippetCode)
+			r.Put("/id/{codeid}/sort-order", setSnippetSortOrder)
+			r.Post("/id/{codeid}/tags", addSnippetTag)
+			r.Delete("/id/{codeid}/tags/{tag}", removeSnippetTag)
+			r.Post("/tags/rename", renameTag)
+			r.Get("/id/{codeid}/share", shareSnippet)
+			r.Get("/id/{codeid}/raw", getRawSnippet)
+			r.Post("/id/{codeid}/comments", addSnippetComment)
+			r.Post("/id/{codeid}/vote", voteSnippet)
+			r.Post("/id/{codeid}/rename", renameSnippet)
+			r.Post("/id/{codeid}/move", moveSnippet)
+			r.Post("/id/{codeid}/archive", archiveSnippet)
+			r.Post("/id/{codeid}/unarchive", unarchiveSnippet)
+			r.Post("/id/{codeid}/compare", compareSnippet)
+			r.Post("/id/{codeid}/history/{version}/promote", promoteSnippetVersion)
+			r.With(requireAPIKey).Post("/id/{codeid}/lock", lockSnippet)
+			r.With(requireAPIKey).Post("/id/{codeid}/unlock", unlockSnippet)
+		})
 	})
 	return rg
 }
 
+// snippetIDParam parses the {codeid} URL parameter, writing the 400 response itself when it is not a valid id
+func snippetIDParam(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(chi.URLParam(r, "codeid")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidID, "The id is invalid")
+		return id, false
+	}
+	return id, true
+}
+
+// newSnippetModel builds the document for a new snippet from validated client input,
+// the id and creation time are always set by the server
+func newSnippetModel(c CodeSnippet) CodeSnippetModel {
+	m := CodeSnippetModel{
+		ID:          primitive.NewObjectID(),
+		CreatedAt:   time.Now(),
+		SnippetName: c.SnippetName,
+		Language:    normalizeLanguage(c.Language),
+		Tags:        c.Tags,
+		ExpiresAt:   c.ExpiresAt,
+		MaxViews:    c.MaxViews,
+	}
+	// validateSnippet already refused a folder validFolder doesn't accept
+	m.Folder, _ = validFolder(c.Folder)
+	m.Source = c.Source
+	if m.Source == "" {
+		m.Source = defaultSource
+	}
+	setCode(&m, c.Code)
+	return m
+}
+
+// preserveCreatedAt keeps the created_at the client sent (if any) instead of the creation time.
+// It must be an RFC 3339 timestamp string, anything else is returned as a field error.
+func preserveCreatedAt(c CodeSnippet, m *CodeSnippetModel) []fieldError {
+	if len(c.requestedCreatedAt) == 0 || string(c.requestedCreatedAt) == "null" {
+		return nil
+	}
+	var createdAt string
+	var parsed time.Time
+	err := json.Unmarshal(c.requestedCreatedAt, &createdAt)
+	if err == nil {
+		parsed, err = time.Parse(time.RFC3339, createdAt)
+	}
+	if err != nil {
+		return []fieldError{{
+			Field:   "created_at",
+			Code:    errCodeInvalidTimestamp,
+			Message: "created_at must be an RFC 3339 timestamp string, e.g. 2023-01-02T15:04:05Z",
+		}}
+	}
+	m.CreatedAt = parsed
+	return nil
+}
+
+// toCodeSnippet converts the bson model stored in the database into the json struct sent to the client
+func toCodeSnippet(m CodeSnippetModel) CodeSnippet {
+	return CodeSnippet{
+		ID:             m.ID.Hex(),
+		SnippetName:    m.SnippetName,
+		Code:           m.plainCode(),
+		Language:       m.Language,
+		Tags:           m.Tags,
+		SortOrder:      m.SortOrder,
+		Owner:          m.Owner,
+		Folder:         m.Folder,
+		Locked:         m.Locked,
+		Archived:       m.Archived,
+		Score:          m.Score,
+		ShareCount:     m.ShareCount,
+		ViewCount:      m.ViewCount,
+		Slug:           m.Slug,
+		Source:         m.Source,
+		MaxViews:       m.MaxViews,
+		LastAccessedAt: m.LastAccessedAt,
+		ExpiresAt:      m.ExpiresAt,
+		CreatedAt:      m.CreatedAt,
+		UpdatedAt:      m.UpdatedAt,
+	}
+}
+
+// normalizeLanguage stores languages lowercase so "Go" and "go" group together
+func normalizeLanguage(language string) string {
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+/*
+ensureCollections creates the collections on a fresh database.
+
+	Mongo creates a collection on the first write anyway and reads of a missing one simply find
+	nothing, but a few commands (listIndexes for /readyz, for one) fail with NamespaceNotFound
+	instead. Creating them up front means a first run behaves like any other. A collection that
+	already exists is fine, any other failure is only logged like in ensureIndexes.
+*/
+func ensureCollections() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, name := range []string{collectionName, commentsCollectionName, votesCollectionName, historyCollectionName} {
+		if err := db.CreateCollection(ctx, name); err != nil && !isCommandError(err, namespaceExistsCode) {
+			log.Printf("failed to create the %s collection: %s\n", name, err)
+		}
+	}
+}
+
+// ensureIndexes creates the indexes the handlers rely on and returns how many failed. Failing
+// to create one is logged rather than fatal, the queries still work without it, only slower.
+func ensureIndexes() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// case-insensitive name lookups (?ci=true) can only use an index built with the same collation
+		{
+			Keys:    bson.D{{Key: "snippetname", Value: 1}},
+			Options: options.Index().SetName("snippetname_ci").SetCollation(snippetNameCollation),
+		},
+		// exact name lookups and the anchored prefix search of the autocomplete endpoint. Unique, so
+		// two creates or renames racing for one name can't both win, see prepareUniqueNameIndex
+		{
+			Keys:    bson.D{{Key: "snippetname", Value: 1}},
+			Options: options.Index().SetName(nameIndexName).SetUnique(true),
+		},
+		// counting the snippets of one owner for MAX_SNIPPETS_PER_OWNER
+		{
+			Keys:    bson.D{{Key: "owner", Value: 1}},
+			Options: options.Index().SetName("owner_1"),
+		},
+		// full text search over names and code, a match in the name weighs more
+		{
+			Keys:    bson.D{{Key: "snippetname", Value: "text"}, {Key: "code", Value: "text"}},
+			Options: options.Index().SetName("snippet_text").SetWeights(bson.M{"snippetname": 10, "code": 1}),
+		},
+		// deletes a snippet as soon as its expiresAt has passed, documents without it are never deleted
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+		// the top list, highest score first
+		{
+			Keys:    bson.D{{Key: "score", Value: -1}, {Key: "createAt", Value: -1}},
+			Options: options.Index().SetName("score_-1_createAt_-1"),
+		},
+		// the most shared list
+		{
+			Keys:    bson.D{{Key: "shareCount", Value: -1}, {Key: "createAt", Value: -1}},
+			Options: options.Index().SetName("shareCount_-1_createAt_-1"),
+		},
+		// the archived list, most recently archived first
+		{
+			Keys:    bson.D{{Key: "archived", Value: 1}, {Key: "archivedAt", Value: -1}},
+			Options: options.Index().SetName("archived_1_archivedAt_-1"),
+		},
+		// listing the snippets created by one client (?source=)
+		{
+			Keys:    bson.D{{Key: "source", Value: 1}},
+			Options: options.Index().SetName("source_1"),
+		},
+		// listing the snippets of one folder, newest first
+		{
+			Keys:    bson.D{{Key: "folder", Value: 1}, {Key: "createAt", Value: -1}},
+			Options: options.Index().SetName("folder_1_createAt_-1"),
+		},
+		// lookups by slug, unique so two snippets can't end up with the same one (see insertWithSlug)
+		{
+			Keys: bson.D{{Key: "slug", Value: 1}},
+			Options: options.Index().SetName(slugIndexName).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+		},
+		// the ?min_bytes=&max_bytes= size filter of the listings
+		{
+			Keys:    bson.D{{Key: "codeBytes", Value: 1}},
+			Options: options.Index().SetName("codeBytes_1"),
+		},
+		// grouping and backfilling by code hash for the duplicates endpoint
+		{
+			Keys:    bson.D{{Key: "codeHash", Value: 1}},
+			Options: options.Index().SetName("codeHash_1"),
+		},
+	}
+
+	failed := 0
+	if err := prepareUniqueNameIndex(ctx); err != nil {
+		// the unique index can't be built over the duplicates, its CreateOne below fails and counts
+		log.Printf("failed to prepare the unique name index: %s\n", err)
+	}
+	for _, index := range indexes {
+		if _, err := db.Collection(collectionName).Indexes().CreateOne(ctx, index); err != nil {
+			log.Printf("failed to create %s index: %s\n", *index.Options.Name, err)
+			failed++
+		}
+	}
+
+	// listing the comments of one snippet in order, and deleting them with the snippet
+	commentsIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "snippetId", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("snippetId_1__id_1"),
+	}
+	if _, err := db.Collection(commentsCollectionName).Indexes().CreateOne(ctx, commentsIndex); err != nil {
+		log.Printf("failed to create %s index: %s\n", *commentsIndex.Options.Name, err)
+		failed++
+	}
+
+	// one vote per user and snippet, without it a user could vote twice by racing two requests
+	votesIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "snippetId", Value: 1}, {Key: "userId", Value: 1}},
+		Options: options.Index().SetName("snippetId_1_userId_1").SetUnique(true),
+	}
+	if _, err := db.Collection(votesCollectionName).Indexes().CreateOne(ctx, votesIndex); err != nil {
+		log.Printf("failed to create %s index: %s\n", *votesIndex.Options.Name, err)
+		failed++
+	}
+
+	// history listing newest first, unique so two changes can't record the same version number
+	historyIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "snippetId", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetName("snippetId_1_version_-1").SetUnique(true),
+	}
+	if _, err := db.Collection(historyCollectionName).Indexes().CreateOne(ctx, historyIndex); err != nil {
+		log.Printf("failed to create %s index: %s\n", *historyIndex.Options.Name, err)
+		failed++
+	}
+	return failed
+}
+
+// queryBool reports whether the query parameter is set to a true value ("true", "1", ...)
+func queryBool(r *http.Request, name string) bool {
+	v, err := strconv.ParseBool(r.URL.Query().Get(name))
+	return err == nil && v
+}
+
 // This is a utility function that checks if an error occurred and,
 // if so, logs it as a fatal error, which usually terminates the application.
 func checkErr(err error) {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errorCode returns the "code" of an error response
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not an error envelope: %s", rec.Body.String(), err)
+	}
+	return body.Code
+}
+
+// TestUpdateSnippetRejectsBadRequests covers the 400s of PUT, they are answered before the database is used
+func TestUpdateSnippetRejectsBadRequests(t *testing.T) {
+	router := chi.NewRouter()
+	router.Put("/{codeid}", updateSnippet)
+	id := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode string
+	}{
+		{"invalid id", "/not-an-id", `{"snippet_name": "a", "code": "b"}`, errCodeInvalidID},
+		{"body is not json", "/" + id, `{"snippet_name":`, errCodeInvalidBody},
+		{"missing name", "/" + id, `{"code": "b"}`, errCodeSnippetNameRequired},
+		{"missing code", "/" + id, `{"snippet_name": "a"}`, errCodeCodeRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400 (body %s)", rec.Code, rec.Body.String())
+			}
+			if code := errorCode(t, rec); code != tt.wantCode {
+				t.Errorf("code = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteUpdateFailure(t *testing.T) {
+	notLockedSnippet := func() (bool, error) { return false, nil }
+	lockedSnippet := func() (bool, error) { return true, nil }
+	missingSnippet := func() (bool, error) { return false, mongo.ErrNoDocuments }
+	duplicate := mongo.CommandError{Code: duplicateKeyCode, Message: "E11000 duplicate key error"}
+
+	tests := []struct {
+		name        string
+		err         error
+		conditional bool
+		locked      func() (bool, error)
+		wantStatus  int
+		wantCode    string
+	}{
+		{"missing snippet", mongo.ErrNoDocuments, false, missingSnippet, http.StatusNotFound, errCodeNotFound},
+		{"missing snippet, conditional", mongo.ErrNoDocuments, true, missingSnippet, http.StatusNotFound, errCodeNotFound},
+		{"locked snippet", mongo.ErrNoDocuments, false, lockedSnippet, http.StatusLocked, errCodeLocked},
+		{"locked snippet, conditional", mongo.ErrNoDocuments, true, lockedSnippet, http.StatusLocked, errCodeLocked},
+		{"modified since expected_updated_at", mongo.ErrNoDocuments, true, notLockedSnippet, http.StatusConflict, errCodeConflict},
+		{"lock lookup failed, conditional", mongo.ErrNoDocuments, true, func() (bool, error) { return false, errors.New("timeout") }, http.StatusNotFound, errCodeNotFound},
+		{"duplicate name", duplicate, false, notLockedSnippet, http.StatusConflict, errCodeDuplicateName},
+		{"database error", errors.New("connection reset"), false, notLockedSnippet, http.StatusInternalServerError, errCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := 0
+			locked := func() (bool, error) {
+				lookups++
+				return tt.locked()
+			}
+			rec := httptest.NewRecorder()
+			writeUpdateFailure(rec, tt.err, "hello", tt.conditional, locked)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if code := errorCode(t, rec); code != tt.wantCode {
+				t.Errorf("code = %s, want %s", code, tt.wantCode)
+			}
+			// the lock is only looked up when the filter matched nothing
+			if tt.err != mongo.ErrNoDocuments && lookups > 0 {
+				t.Errorf("locked was called %d times for %v", lookups, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// readOnly is flipped at runtime by the admin endpoint, so it must be safe for concurrent use
+var readOnly atomic.Bool
+
+// setReadOnly changes the read-only mode and logs the transition
+func setReadOnly(enabled bool) {
+	if readOnly.Swap(enabled) != enabled {
+		log.Printf("read-only mode changed: enabled=%t\n", enabled)
+	}
+}
+
+// inFlight is the number of requests currently being handled, reported in /status
+var inFlight atomic.Int64
+
+// concurrencySlots is the global semaphore of concurrencyLimiter, nil when MAX_CONCURRENT is 0 (no limit)
+var concurrencySlots chan struct{}
+
+// concurrencyRetryAfter is the Retry-After (seconds) sent with a 429, saturation is usually short lived
+const concurrencyRetryAfter = "1"
+
+/*
+concurrencyLimiter caps the number of requests handled at the same time to MAX_CONCURRENT.
+
+	A request that finds every slot taken is rejected right away with 429 and Retry-After
+	instead of queueing, so a burst can't pile up work on Mongo. The slot is released in a
+	defer, so it is returned even when the handler panics. WebSocket upgrades are let through,
+	a watcher stays open for as long as the client wants and would hold its slot all along,
+	they have their own cap (MAX_WATCHERS, see watchSnippet).
+*/
+func concurrencyLimiter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if concurrencySlots != nil {
+			select {
+			case concurrencySlots <- struct{}{}:
+				defer func() { <-concurrencySlots }()
+			default:
+				w.Header().Set("Retry-After", concurrencyRetryAfter)
+				writeError(w, http.StatusTooManyRequests, errCodeTooManyRequests, "the server is busy, please retry shortly")
+				return
+			}
+		}
+
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWriteMethod reports whether the http method modifies data
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// readOnlyGuard rejects every write request with a 503 while read-only mode is on, reads pass through
+func readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly.Load() && isWriteMethod(r.Method) {
+			writeError(w, http.StatusServiceUnavailable, errCodeReadOnly,
+				"the service is in read-only mode for maintenance, please try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIKey only lets requests through that carry the admin key in the X-API-Key header
+func requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminAPIKey == "" {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "admin endpoints are disabled, set ADMIN_API_KEY to enable them")
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminAPIKey)) != 1 {
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "missing or invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+bodyLimit caps every request body at MAX_BODY_BYTES.
+
+	A request announcing a larger Content-Length gets a 413 before any handler runs. Bodies
+	without a length (chunked) are wrapped in MaxBytesReader instead, reading past the limit
+	fails inside the JSON decoder or the multipart parser, and writeDecodeError (or the upload
+	handler) turns that into the same 413. Handlers can still set a tighter limit of their own.
+*/
+func bodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > cfg.MaxBodyBytes {
+			writeBodyTooLarge(w, r, cfg.MaxBodyBytes)
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyTooLarge logs and rejects a request whose body is over limit bytes
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	log.Printf("rejected oversized request: %s %s from %s, limit %d bytes\n", r.Method, r.URL.Path, r.RemoteAddr, limit)
+	writeError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, fmt.Sprintf("the request body must not exceed %d bytes", limit))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// moderationRule is one banned pattern, Line is its line in MODERATION_RULES_FILE and identifies the rule
+type moderationRule struct {
+	Line    int
+	Pattern *regexp.Regexp
+}
+
+// moderationRules is loaded once in init(), empty (the default) disables moderation
+var moderationRules []moderationRule
+
+// moderationMatch tells the client which rule rejected which field
+type moderationMatch struct {
+	Field   string `json:"field"`
+	Rule    int    `json:"rule"`
+	Pattern string `json:"pattern"`
+}
+
+/*
+loadModerationRules reads the banned patterns, one Go regular expression per line.
+
+	Blank lines and lines starting with # are skipped, a rule is numbered by its line so the
+	file can be edited without renumbering anything by hand. A pattern that doesn't compile
+	fails the start instead of being skipped, a typo shouldn't silently let content through.
+	Patterns are case-sensitive, (?i) at the start of a line makes one case-insensitive.
+*/
+func loadModerationRules(path string) ([]moderationRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []moderationRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pattern, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, line, err)
+		}
+		rules = append(rules, moderationRule{Line: line, Pattern: pattern})
+	}
+	return rules, scanner.Err()
+}
+
+// moderateSnippet checks the name and the code against the rules and returns the first match,
+// nil when nothing matched or moderation is disabled. Empty values are not checked.
+func moderateSnippet(name, code string) *moderationMatch {
+	for _, field := range []struct{ name, value string }{{"snippet_name", name}, {"code", code}} {
+		if field.value == "" {
+			continue
+		}
+		for _, rule := range moderationRules {
+			if rule.Pattern.MatchString(field.value) {
+				return &moderationMatch{Field: field.name, Rule: rule.Line, Pattern: rule.Pattern.String()}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *moderationMatch) message() string {
+	return fmt.Sprintf("the %s contains disallowed content (moderation rule %d)", m.Field, m.Rule)
+}
+
+// writeModerationRejected sends the 422 for content a moderation rule matched
+func writeModerationRejected(w http.ResponseWriter, m *moderationMatch) {
+	rnd.JSON(w, http.StatusUnprocessableEntity, renderer.M{
+		"code":    errCodeContentRejected,
+		"message": m.message(),
+		"rule":    m,
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// discoverableMethods are the methods an OPTIONS response can list, in the order of the Allow header
+var discoverableMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+/*
+optionsResponder answers OPTIONS requests with a 204 and an Allow header listing the methods
+routes has for the path, e.g. OPTIONS /code-snippets/id/{codeid}.
+
+	The methods are looked up with chi's Match on the registered routes, so the header can't
+	drift from the router. HEAD is allowed wherever GET is (middleware.GetHead serves it) and
+	OPTIONS always, a path with a PATCH route also gets Accept-Patch, PATCH takes a JSON merge
+	patch. This is discovery, not CORS: no Access-Control headers are sent.
+
+	A path no route matches falls through to the usual 404. When a route answers OPTIONS
+	itself the request is passed on too, that is how a router mounting subrouters hands the
+	request to the subrouter, which then answers with its own optionsResponder.
+*/
+func optionsResponder(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			path := r.URL.Path
+			if r.URL.RawPath != "" {
+				path = r.URL.RawPath
+			}
+			// inside a mounted router only the rest of the path is left to match
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+				path = rctx.RoutePath
+			}
+			if routes.Match(chi.NewRouteContext(), http.MethodOptions, path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := []string{}
+			for _, method := range discoverableMethods {
+				matched := routes.Match(chi.NewRouteContext(), method, path)
+				if method == http.MethodHead {
+					matched = matched || routes.Match(chi.NewRouteContext(), http.MethodGet, path)
+				}
+				if matched {
+					allowed = append(allowed, method)
+				}
+			}
+			if len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+			if routes.Match(chi.NewRouteContext(), http.MethodPatch, path) {
+				w.Header().Set("Accept-Patch", "application/merge-patch+json")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// userIDHeader identifies the user of a request, there are no accounts so it is whatever the client sends
+const userIDHeader = "X-User-ID"
+
+/*
+takeOwnership records the X-User-ID of a create as the owner of cm and enforces
+MAX_SNIPPETS_PER_OWNER, it reports false when it already answered the request.
+
+	Ownership is off unless SNIPPET_OWNERSHIP=true, then nothing is recorded or counted. A create
+	without the header stays unowned and isn't counted against anyone. The count and the
+	insert are separate steps, so concurrent creates of one owner can go past the limit by
+	the number of requests racing.
+*/
+func takeOwnership(w http.ResponseWriter, r *http.Request, cm *CodeSnippetModel) bool {
+	models := []CodeSnippetModel{*cm}
+	if !takeBatchOwnership(w, r, models) {
+		return false
+	}
+	cm.Owner = models[0].Owner
+	return true
+}
+
+// takeBatchOwnership is takeOwnership for the snippets of one bulk create or import. The
+// whole batch counts against the quota, it is refused as a whole when it doesn't fit.
+func takeBatchOwnership(w http.ResponseWriter, r *http.Request, models []CodeSnippetModel) bool {
+	if !cfg.OwnershipEnabled {
+		return true
+	}
+	owner := strings.TrimSpace(r.Header.Get(userIDHeader))
+	for i := range models {
+		models[i].Owner = owner
+	}
+	if owner == "" || cfg.MaxSnippetsPerOwner == 0 || len(models) == 0 {
+		return true
+	}
+
+	count, err := db.Collection(collectionName).CountDocuments(r.Context(), bson.M{"owner": owner})
+	if err != nil {
+		log.Printf("failed to count the snippets of %q: %s\n", owner, err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save Code Snippet")
+		return false
+	}
+	if count+int64(len(models)) > int64(cfg.MaxSnippetsPerOwner) {
+		rnd.JSON(w, http.StatusForbidden, renderer.M{
+			"code":      errCodeQuotaExceeded,
+			"message":   fmt.Sprintf("%q owns %d snippets, %d more would go past the limit of %d", owner, count, len(models), cfg.MaxSnippetsPerOwner),
+			"usage":     count,
+			"requested": len(models),
+			"limit":     cfg.MaxSnippetsPerOwner,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// withOwnership sets SNIPPET_OWNERSHIP and MAX_SNIPPETS_PER_OWNER for one test
+func withOwnership(t *testing.T, enabled bool, max int) {
+	t.Helper()
+	oldEnabled, oldMax := cfg.OwnershipEnabled, cfg.MaxSnippetsPerOwner
+	cfg.OwnershipEnabled, cfg.MaxSnippetsPerOwner = enabled, max
+	t.Cleanup(func() { cfg.OwnershipEnabled, cfg.MaxSnippetsPerOwner = oldEnabled, oldMax })
+}
+
+// TestTakeOwnershipWithoutCount covers the cases answered before the database is used
+func TestTakeOwnershipWithoutCount(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		max       int
+		user      string
+		wantOwner string
+	}{
+		{"ownership off", false, 1, "alice", ""},
+		{"no user", true, 1, "", ""},
+		{"unlimited", true, 0, " alice ", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOwnership(t, tt.enabled, tt.max)
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.user != "" {
+				req.Header.Set(userIDHeader, tt.user)
+			}
+			var cm CodeSnippetModel
+			rec := httptest.NewRecorder()
+			if !takeOwnership(rec, req, &cm) {
+				t.Fatalf("takeOwnership refused the create: %d %s", rec.Code, rec.Body.String())
+			}
+			if cm.Owner != tt.wantOwner {
+				t.Errorf("owner = %q, want %q", cm.Owner, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestOwnerQuota(t *testing.T) {
+	testDatabase(t)
+	withOwnership(t, true, 2)
+
+	create := func(user string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"snippet_name": "%s", "code": "x"}`, primitive.NewObjectID().Hex())
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(userIDHeader, user)
+		rec := httptest.NewRecorder()
+		createSnippet(rec, req)
+		return rec
+	}
+	for i := 1; i <= 2; i++ {
+		if rec := create("alice"); rec.Code != http.StatusCreated {
+			t.Fatalf("create %d = %d %s, want 201", i, rec.Code, rec.Body.String())
+		}
+	}
+	rec := create("alice")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("create over the quota = %d %s, want 403", rec.Code, rec.Body.String())
+	}
+	if code := errorCode(t, rec); code != errCodeQuotaExceeded {
+		t.Errorf("code = %s, want %s", code, errCodeQuotaExceeded)
+	}
+	// the quota is per owner
+	if rec := create("bob"); rec.Code != http.StatusCreated {
+		t.Errorf("create of another owner = %d %s, want 201", rec.Code, rec.Body.String())
+	}
+}
+
+// TestBatchOwnership checks that every snippet of a batch gets the owner, without a quota no count is needed
+func TestBatchOwnership(t *testing.T) {
+	withOwnership(t, true, 0)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(userIDHeader, "alice")
+	models := []CodeSnippetModel{{SnippetName: "a"}, {SnippetName: "b"}}
+	if !takeBatchOwnership(httptest.NewRecorder(), req, models) {
+		t.Fatal("takeBatchOwnership refused the batch")
+	}
+	for _, m := range models {
+		if m.Owner != "alice" {
+			t.Errorf("%s: owner = %q, want alice", m.SnippetName, m.Owner)
+		}
+	}
+}
+
+// TestBulkCreateOwnerQuota covers /bulk, the whole batch counts against the quota
+func TestBulkCreateOwnerQuota(t *testing.T) {
+	testDatabase(t)
+	withOwnership(t, true, 3)
+
+	bulk := func(n int) *httptest.ResponseRecorder {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"snippet_name": "%s", "code": "x"}`, primitive.NewObjectID().Hex())
+		}
+		body := `{"snippets": [` + strings.Join(items, ",") + `]}`
+		req := httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(body))
+		req.Header.Set(userIDHeader, "alice")
+		rec := httptest.NewRecorder()
+		bulkCreateSnippets(rec, req)
+		return rec
+	}
+	if rec := bulk(2); rec.Code != http.StatusCreated {
+		t.Fatalf("bulk of 2 = %d %s, want 201", rec.Code, rec.Body.String())
+	}
+	// one more fits, two don't, and nothing of the refused batch is inserted
+	rec := bulk(2)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("bulk over the quota = %d %s, want 403", rec.Code, rec.Body.String())
+	}
+	if code := errorCode(t, rec); code != errCodeQuotaExceeded {
+		t.Errorf("code = %s, want %s", code, errCodeQuotaExceeded)
+	}
+	if rec := bulk(1); rec.Code != http.StatusCreated {
+		t.Errorf("bulk of the last free snippet = %d %s, want 201", rec.Code, rec.Body.String())
+	}
+}
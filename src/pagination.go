@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// pagination is the effective page a list request asked for, sent back to the client as is
+type pagination struct {
+	Limit  int64 `json:"limit"`
+	Offset int64 `json:"offset"`
+}
+
+/*
+parsePagination reads ?limit= and ?offset= from the query string.
+
+	The limit is clamped to cfg.MaxPageSize instead of being rejected: a client asking for
+	?limit=1000000 gets MaxPageSize results and can see the limit that was actually applied
+	in the response. A missing limit means cfg.DefaultPageSize. Values that aren't
+	non-negative integers (or a zero limit) are rejected with an error.
+*/
+func parsePagination(r *http.Request) (pagination, error) {
+	p := pagination{Limit: int64(cfg.DefaultPageSize)}
+	q := r.URL.Query()
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 {
+			return p, fmt.Errorf("limit must be a positive integer, got %q", v)
+		}
+		p.Limit = limit
+		if limit > int64(cfg.MaxPageSize) {
+			p.Limit = int64(cfg.MaxPageSize)
+		}
+	}
+
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || offset < 0 {
+			return p, fmt.Errorf("offset must be a non-negative integer, got %q", v)
+		}
+		p.Offset = offset
+	}
+
+	return p, nil
+}
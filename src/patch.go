@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// patchableFields maps the JSON fields a merge patch may contain to their bson field.
+// "snippetname" is the legacy spelling of snippet_name, accepted like in CodeSnippet.UnmarshalJSON.
+var patchableFields = map[string]string{
+	"snippet_name": "snippetname",
+	"snippetname":  "snippetname",
+	"code":         "code",
+	"language":     "language",
+	"tags":         "tags",
+	"sort_order":   "sortOrder",
+	"expires_at":   "expiresAt",
+}
+
+/*
+patchSnippet applies an RFC 7386 JSON Merge Patch to a snippet.
+
+	A field that is absent stays as it is, a field set to null is cleared ($unset) and any other
+	value replaces the stored one. snippet_name and code are required on every snippet, so they
+	can be replaced but not cleared. The patched snippet as a whole goes through validateSnippet,
+	so a patch can't produce a snippet PUT would reject. Only the fields in the patch are written.
+	Like PUT, the content before the patch is kept as a version in the history.
+*/
+func patchSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		writeDecodeError(w, r, err, "the request body must be a JSON object")
+		return
+	}
+
+	var unknown []string
+	for key := range patch {
+		if _, ok := patchableFields[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "these fields can't be patched: "+strings.Join(unknown, ", "))
+		return
+	}
+
+	var current CodeSnippetModel
+	err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+	if current.Locked {
+		writeLocked(w)
+		return
+	}
+
+	// merged is the snippet as it will look after the patch, used for validation only
+	merged := toCodeSnippet(current)
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{}
+
+	for key, raw := range patch {
+		field := patchableFields[key]
+		if string(raw) == "null" {
+			if field == "snippetname" || field == "code" {
+				writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("%s can't be cleared", key))
+				return
+			}
+			unset[field] = ""
+			switch field {
+			case "language":
+				merged.Language = ""
+			case "tags":
+				merged.Tags = nil
+			case "sortOrder":
+				merged.SortOrder = 0
+			case "expiresAt":
+				merged.ExpiresAt = nil
+				unset["expiryRemindedAt"] = ""
+			}
+			continue
+		}
+
+		var err error
+		switch field {
+		case "snippetname":
+			err = json.Unmarshal(raw, &merged.SnippetName)
+			set[field] = merged.SnippetName
+		case "code":
+			if err = json.Unmarshal(raw, &merged.Code); err == nil {
+				codeSet, codeUnset := codeUpdate(merged.Code)
+				for k, v := range codeSet {
+					set[k] = v
+				}
+				for k, v := range codeUnset {
+					unset[k] = v
+				}
+			}
+		case "language":
+			err = json.Unmarshal(raw, &merged.Language)
+			set[field] = normalizeLanguage(merged.Language)
+		case "tags":
+			err = json.Unmarshal(raw, &merged.Tags)
+			set[field] = merged.Tags
+		case "sortOrder":
+			err = json.Unmarshal(raw, &merged.SortOrder)
+			set[field] = merged.SortOrder
+		case "expiresAt":
+			var expiresAt time.Time
+			err = json.Unmarshal(raw, &expiresAt)
+			merged.ExpiresAt = &expiresAt
+			set[field] = expiresAt
+			unset["expiryRemindedAt"] = ""
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidBody, fmt.Sprintf("invalid value for %s", key))
+			return
+		}
+	}
+
+	if errs := validateSnippet(merged); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+	if m := moderateSnippet(merged.SnippetName, merged.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id, "locked": notLocked}), update, opts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if isDuplicateKey(err) {
+		writeError(w, http.StatusConflict, errCodeDuplicateName, fmt.Sprintf("a snippet named %q already exists", merged.SnippetName))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to patch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update snippet")
+		return
+	}
+
+	recordVersion(r.Context(), current, "patch", 0)
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet updated successfully",
+		"data":    snippetResponse(r, updated),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// readinessTimeout bounds the Mongo round trips of one /readyz call, probes usually time out soon after
+const readinessTimeout = 2 * time.Second
+
+/*
+readyHandler answers load balancer readiness probes: 200 when the instance can serve traffic, 503 otherwise.
+
+	It pings Mongo and, when REQUIRED_INDEXES is set (e.g. "snippetname_1,snippet_text", the
+	names ensureIndexes gives its indexes), checks with listIndexes that each of them exists.
+	ensureIndexes only logs a failure, so without this check an instance whose index build failed
+	would pass the probe and serve slow scans or, for the text index, degraded search results.
+*/
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		log.Printf("readiness check failed, database unreachable: %s\n", err)
+		rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{
+			"status": "not_ready",
+			"reason": "database unreachable",
+		})
+		return
+	}
+
+	if len(cfg.RequiredIndexes) > 0 {
+		missing, err := missingIndexes(ctx, cfg.RequiredIndexes)
+		if err != nil {
+			log.Printf("readiness check failed, could not list indexes: %s\n", err)
+			rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{
+				"status": "not_ready",
+				"reason": "could not list indexes",
+			})
+			return
+		}
+		if len(missing) > 0 {
+			rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{
+				"status":          "not_ready",
+				"reason":          "required indexes missing",
+				"missing_indexes": missing,
+			})
+			return
+		}
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"status": "ready",
+	})
+}
+
+// missingIndexes returns the names in required that don't exist on the snippets collection
+func missingIndexes(ctx context.Context, required []string) ([]string, error) {
+	cursor, err := db.Collection(collectionName).Indexes().List(ctx)
+	if isCommandError(err, namespaceNotFoundCode) {
+		// no collection (ensureCollections failed or it was dropped), so none of the indexes exists
+		return required, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	existing := map[string]bool{}
+	for _, index := range indexes {
+		existing[index.Name] = true
+	}
+
+	missing := []string{}
+	for _, name := range required {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
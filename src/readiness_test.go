@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+testDatabase points client and db at a new, empty database for one test and drops it afterwards.
+
+	The tests that need Mongo are skipped unless MONGODB_TEST_URI is set, e.g.
+	MONGODB_TEST_URI=mongodb://localhost:27017 go test ./...
+	Every test gets its own database name, so tests never see each other's documents.
+*/
+func testDatabase(t *testing.T) {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %s", uri, err)
+	}
+	oldClient, oldDB := client, db
+	client, db = c, c.Database("snippets_test_"+primitive.NewObjectID().Hex())
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Errorf("failed to drop %s: %s", db.Name(), err)
+		}
+		c.Disconnect(ctx)
+		client, db = oldClient, oldDB
+	})
+}
+
+// collectionIndexes returns the index names of a collection
+func collectionIndexes(t *testing.T, name string) map[string]bool {
+	t.Helper()
+	cursor, err := db.Collection(name).Indexes().List(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list the indexes of %s: %s", name, err)
+	}
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err := cursor.All(context.Background(), &indexes); err != nil {
+		t.Fatalf("failed to list the indexes of %s: %s", name, err)
+	}
+	names := map[string]bool{}
+	for _, index := range indexes {
+		names[index.Name] = true
+	}
+	return names
+}
+
+// readiness calls /readyz and returns the status code
+func readiness(t *testing.T) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+// emptyResponses calls the read endpoints a client tries first and checks that they answer 200
+// with nothing in them
+func emptyResponses(t *testing.T, when string) {
+	t.Helper()
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"/code-snippets?envelope=true", getAllSnippets, "[]"},
+		{"/code-snippets/search?q=hello&envelope=true", searchSnippets, "[]"},
+		{"/code-snippets/stats?envelope=true", getSnippetStats, "[]"},
+		{"/code-snippets/count?envelope=true", countSnippets, `{"count":0}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: GET %s = %d %s, want 200", when, tt.path, rec.Code, rec.Body.String())
+			continue
+		}
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: GET %s: response %q is not an envelope: %s", when, tt.path, rec.Body.String(), err)
+			continue
+		}
+		var got bytes.Buffer
+		json.Compact(&got, body.Data)
+		if got.String() != tt.want {
+			t.Errorf("%s: GET %s: data = %s, want %s", when, tt.path, got.String(), tt.want)
+		}
+	}
+}
+
+// TestFirstRun starts against an empty database, the way a fresh deployment does
+func TestFirstRun(t *testing.T) {
+	testDatabase(t)
+
+	wantIndexes := map[string][]string{
+		collectionName: {
+			"snippetname_ci", "snippetname_1", "snippet_text", "expiresAt_ttl",
+			"score_-1_createAt_-1", "shareCount_-1_createAt_-1", "archived_1_archivedAt_-1",
+			"source_1", "owner_1", "folder_1_createAt_-1", slugIndexName, "codeBytes_1", "codeHash_1",
+		},
+		commentsCollectionName: {"snippetId_1__id_1"},
+		votesCollectionName:    {"snippetId_1_userId_1"},
+		historyCollectionName:  {"snippetId_1_version_-1"},
+	}
+
+	oldRequired := cfg.RequiredIndexes
+	cfg.RequiredIndexes = wantIndexes[collectionName]
+	t.Cleanup(func() { cfg.RequiredIndexes = oldRequired })
+
+	// before the startup steps ran nothing exists, the probe must not pass
+	if status := readiness(t); status != http.StatusServiceUnavailable {
+		t.Errorf("readiness on an empty database = %d, want 503", status)
+	}
+	// the collection doesn't even exist yet, search has no text index to use
+	emptyResponses(t, "before the startup steps")
+
+	// a second run finds everything in place and must not fail either
+	for run := 1; run <= 2; run++ {
+		ensureCollections()
+		if failed := ensureIndexes(); failed != 0 {
+			t.Fatalf("run %d: %d indexes failed", run, failed)
+		}
+	}
+
+	names, err := db.ListCollectionNames(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatalf("failed to list the collections: %s", err)
+	}
+	existing := map[string]bool{}
+	for _, name := range names {
+		existing[name] = true
+	}
+	for name, indexes := range wantIndexes {
+		if !existing[name] {
+			t.Errorf("collection %s was not created", name)
+			continue
+		}
+		got := collectionIndexes(t, name)
+		for _, index := range indexes {
+			if !got[index] {
+				t.Errorf("index %s on %s was not created", index, name)
+			}
+		}
+	}
+
+	if status := readiness(t); status != http.StatusOK {
+		t.Errorf("readiness after the first run = %d, want 200", status)
+	}
+	emptyResponses(t, "after the first run")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	relatedDefaultLimit = 5
+	relatedMaxLimit     = 20
+)
+
+// relatedSnippet is a snippet in the related response together with how much it has in common
+type relatedSnippet struct {
+	CodeSnippet
+	SharedTags   int  `json:"shared_tags"`
+	SameLanguage bool `json:"same_language"`
+}
+
+func (s relatedSnippet) MarshalJSON() ([]byte, error) {
+	return marshalSnippetWith(s.CodeSnippet, struct {
+		SharedTags   int  `json:"shared_tags"`
+		SameLanguage bool `json:"same_language"`
+	}{s.SharedTags, s.SameLanguage})
+}
+
+// relatedSnippetModel decodes the aggregation output: the snippet plus the computed overlap
+type relatedSnippetModel struct {
+	CodeSnippetModel `bson:",inline"`
+	SharedTags       int  `bson:"sharedTags"`
+	SameLanguage     bool `bson:"sameLanguage"`
+}
+
+/*
+getRelatedSnippets returns other snippets that share tags or the language with {codeid}.
+
+	The number of shared tags is computed with $setIntersection, snippets sharing the most
+	tags come first, a shared language breaks ties and the newest wins after that.
+	?limit= caps the number of results (default 5, at most 20).
+*/
+func getRelatedSnippets(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	limit := relatedDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "limit must be a positive integer")
+			return
+		}
+		limit = n
+		if limit > relatedMaxLimit {
+			limit = relatedMaxLimit
+		}
+	}
+
+	var snippet CodeSnippetModel
+	opts := options.FindOne().SetProjection(bson.M{"tags": 1, "language": 1})
+	err := db.Collection(collectionName).FindOne(r.Context(), bson.M{"_id": id}, opts).Decode(&snippet)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+
+	related := []relatedSnippet{}
+
+	candidates := bson.A{}
+	if len(snippet.Tags) > 0 {
+		candidates = append(candidates, bson.M{"tags": bson.M{"$in": snippet.Tags}})
+	}
+	if snippet.Language != "" {
+		candidates = append(candidates, bson.M{"language": snippet.Language})
+	}
+	if len(candidates) == 0 {
+		// nothing to compare with
+		respond(w, r, http.StatusOK, renderer.M{"data": related})
+		return
+	}
+
+	tags := snippet.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$ne": id}, "$or": candidates}}},
+		{{Key: "$addFields", Value: bson.M{
+			"sharedTags":   bson.M{"$size": bson.M{"$setIntersection": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, tags}}},
+			"sameLanguage": bson.M{"$and": bson.A{snippet.Language != "", bson.M{"$eq": bson.A{"$language", snippet.Language}}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "sharedTags", Value: -1}, {Key: "sameLanguage", Value: -1}, {Key: "createAt", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := db.Collection(collectionName).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("failed to fetch related snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch related snippets")
+		return
+	}
+
+	found := []relatedSnippetModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch related snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch related snippets")
+		return
+	}
+
+	for _, s := range found {
+		related = append(related, relatedSnippet{
+			CodeSnippet:  snippetResponse(r, s.CodeSnippetModel),
+			SharedTags:   s.SharedTags,
+			SameLanguage: s.SameLanguage,
+		})
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": related,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+renameSnippet changes only the name of a snippet, body: {"new_name": "..."}.
+
+	The name is what GET /code-snippets/{snippetName} looks snippets up by, so a rename refuses
+	a name another snippet already has (409 with suggestions). The lookup catches the common
+	case; two renames racing to the same free name are only stopped when the name has a unique
+	index, the loser then gets the duplicate key error, also as a 409. The update itself is a
+	single FindOneAndUpdate and the old name is kept in the history like any other change.
+	Renaming a snippet to its current name succeeds without a change.
+*/
+func renameSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		NewName string `json:"new_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"new_name": "..."}`)
+		return
+	}
+	name := strings.TrimSpace(body.NewName)
+
+	// the name rules are the ones of validateSnippet, only its name errors matter here
+	var errs []fieldError
+	for _, e := range validateSnippet(CodeSnippet{SnippetName: name}) {
+		if e.Field == "snippet_name" {
+			e.Field = "new_name"
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+	if m := moderateSnippet(name, ""); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	taken, err := db.Collection(collectionName).CountDocuments(r.Context(), bson.M{"snippetname": name, "_id": bson.M{"$ne": id}}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("failed to look up snippet name: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to rename snippet")
+		return
+	}
+	if taken > 0 {
+		writeNameTaken(w, r, name)
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"snippetname": name, "updatedAt": now}}
+	// Before: the previous name goes to the history, the response is the old document with the new name
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var before CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id, "locked": notLocked}), update, opts).Decode(&before)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if isDuplicateKey(err) {
+		writeNameTaken(w, r, name)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to rename snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to rename snippet")
+		return
+	}
+
+	if before.SnippetName != name {
+		recordVersion(r.Context(), before, "rename", 0)
+	}
+	renamed := before
+	renamed.SnippetName = name
+	renamed.UpdatedAt = &now
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Snippet renamed successfully",
+		"data":    snippetResponse(r, renamed),
+	})
+}
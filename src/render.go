@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// templateVarName is what a query parameter must look like to be usable as a template variable
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// errRenderTooLarge means the rendered text grew past MAX_CODE_BYTES
+var errRenderTooLarge = errors.New("rendered snippet is too large")
+
+// limitedBuffer is a bytes.Buffer that refuses to grow past max bytes
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errRenderTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+/*
+renderSnippet renders a snippet's code as a text/template, filling placeholders from the query string.
+
+	GET /id/{codeid}/render?name=World turns both {{name}} and {{.name}} into "World". Each query
+	parameter becomes a template function returning its value (that is what makes the short
+	{{name}} form work) and a key of the data map. Nothing else is exposed: no functions that
+	touch files, the network or the process, only text/template's own builtins (printf, len, ...).
+	A placeholder without a matching parameter, or a template that doesn't parse, is a 400.
+	The output is capped at MAX_CODE_BYTES. GET /id/{codeid} keeps returning the raw code.
+*/
+func renderSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var foundSnippet CodeSnippetModel
+	err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&foundSnippet)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+	if foundSnippet.MaxViews > 0 {
+		writeError(w, http.StatusConflict, errCodeConflict, "a burn after reading snippet can only be read through GET /code-snippets/id/{codeid}")
+		return
+	}
+
+	vars := map[string]string{}
+	funcs := template.FuncMap{}
+	for key, values := range r.URL.Query() {
+		if !templateVarName.MatchString(key) {
+			continue
+		}
+		value := values[0]
+		vars[key] = value
+		funcs[key] = func() string { return value }
+	}
+
+	tmpl, err := template.New("snippet").Funcs(funcs).Option("missingkey=error").Parse(foundSnippet.plainCode())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidTemplate, templateErrorMessage(err))
+		return
+	}
+
+	out := &limitedBuffer{max: cfg.MaxCodeBytes}
+	if err := tmpl.Execute(out, vars); err != nil {
+		if errors.Is(err, errRenderTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+				fmt.Sprintf("the rendered snippet exceeds %d bytes", cfg.MaxCodeBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, errCodeInvalidTemplate, templateErrorMessage(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, &out.Buffer)
+}
+
+// templateErrorMessage turns a text/template error into a client message, an undefined
+// function in the short {{name}} form is reported as the missing variable it really is
+func templateErrorMessage(err error) string {
+	msg := err.Error()
+	if i := strings.Index(msg, "function "); i >= 0 && strings.HasSuffix(msg, " not defined") {
+		name := strings.TrimSuffix(msg[i+len("function "):], " not defined")
+		return fmt.Sprintf("missing template variable %s, pass it as ?%s=...", name, strings.Trim(name, `"`))
+	}
+	if i := strings.Index(msg, "map has no entry for key "); i >= 0 {
+		name := msg[i+len("map has no entry for key "):]
+		return fmt.Sprintf("missing template variable %s, pass it as ?%s=...", name, strings.Trim(name, `"`))
+	}
+	return "the snippet is not a valid template: " + msg
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"gopkg.in/yaml.v2"
+)
+
+// jsonpCallbackPattern only allows plain JavaScript identifiers, so the callback can't inject code
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+/*
+respond writes the successful response of a read endpoint.
+
+	By default the payload is wrapped: {"data": <snippet or list>, "pagination": {...}}.
+	With ?envelope=false (or RESPONSE_ENVELOPE=false as the server default) only the value of
+	"data" is sent, i.e. the bare snippet object or array. The pagination block of list
+	endpoints then moves to the X-Pagination-Limit and X-Pagination-Offset headers, any other
+	top level keys are dropped.
+
+	GET requests with ?callback=fnName are answered as JSONP, i.e. fnName({...}); with
+	Content-Type application/javascript, for legacy embeds that load the data with a script tag.
+	Requests with ?format=yaml or Accept: application/yaml get the same payload as YAML.
+	Everything else is plain JSON.
+*/
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if m, ok := v.(renderer.M); ok && !wantEnvelope(r) {
+		if data, ok := m["data"]; ok {
+			if page, ok := m["pagination"].(pagination); ok {
+				w.Header().Set("X-Pagination-Limit", strconv.FormatInt(page.Limit, 10))
+				w.Header().Set("X-Pagination-Offset", strconv.FormatInt(page.Offset, 10))
+			}
+			v = data
+		}
+	}
+
+	if callback := r.URL.Query().Get("callback"); callback != "" && r.Method == http.MethodGet {
+		if !jsonpCallbackPattern.MatchString(callback) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "callback must be a valid JavaScript identifier")
+			return
+		}
+		rnd.JSONP(w, status, callback, v)
+		return
+	}
+
+	if wantYAML(r) {
+		writeYAML(w, status, v)
+		return
+	}
+
+	rnd.JSON(w, status, v)
+}
+
+// yamlMediaTypes are the Accept values answered with YAML, application/yaml is the registered one
+var yamlMediaTypes = map[string]bool{
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
+// wantYAML reports whether the client asked for YAML, through ?format=yaml or the Accept header
+func wantYAML(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "yaml" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && yamlMediaTypes[mediaType] {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonMediaType is the Accept value that makes a list stream one JSON value per line
+const ndjsonMediaType = "application/x-ndjson"
+
+// wantNDJSON reports whether the client asked for a streamed NDJSON list through the Accept header
+func wantNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == ndjsonMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+writeYAML sends v as YAML.
+
+	v goes through JSON first and the JSON is read back as YAML (JSON is valid YAML), so
+	the keys are exactly the json tags of the structs, e.g. snippet_name, and no struct needs
+	a second set of yaml tags. Map keys come out sorted.
+*/
+func writeYAML(w http.ResponseWriter, status int, v interface{}) {
+	var generic interface{}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = yaml.Unmarshal(b, &generic)
+	}
+	if err == nil {
+		b, err = yaml.Marshal(generic)
+	}
+	if err != nil {
+		log.Printf("failed to encode yaml response: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to encode response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// wantEnvelope reports whether the response should be wrapped in {"data": ...}, ?envelope= wins over the server default
+func wantEnvelope(r *http.Request) bool {
+	if v, err := strconv.ParseBool(r.URL.Query().Get("envelope")); err == nil {
+		return v
+	}
+	return cfg.ResponseEnvelope
+}
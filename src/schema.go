@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// schemaField describes one field of a snippet for clients that build their forms from the schema.
+// The constraints are those of validateSnippet, zero values mean the field has no such constraint.
+type schemaField struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Items         string   `json:"items,omitempty"`
+	Required      bool     `json:"required"`
+	ReadOnly      bool     `json:"read_only"`
+	MinLength     int      `json:"min_length,omitempty"`
+	MaxLength     int      `json:"max_length,omitempty"`
+	MaxBytes      int      `json:"max_bytes,omitempty"`
+	MaxItems      int      `json:"max_items,omitempty"`
+	ItemMaxLength int      `json:"item_max_length,omitempty"`
+	Minimum       *int     `json:"minimum,omitempty"`
+	Enum          []string `json:"enum,omitempty"`
+	ErrorCodes    []string `json:"error_codes,omitempty"`
+	Description   string   `json:"description"`
+}
+
+/*
+snippetSchema lists the fields of a snippet with their types and constraints.
+
+	The limits come from the same config values and constants validateSnippet checks against
+	(MIN_NAME_LEN, MAX_CODE_BYTES, ALLOWED_LANGUAGES, maxTagLen, ...), so changing the config
+	changes the schema too. A new rule in validateSnippet needs its constraint added here.
+*/
+func snippetSchema() []schemaField {
+	zero := 0
+	var languages []string
+	if len(cfg.AllowedLanguages) > 0 {
+		languages = cfg.AllowedLanguages
+	}
+	return []schemaField{
+		{Name: "id", Type: "string", ReadOnly: true, Description: "set by the server"},
+		{
+			Name: "snippet_name", Type: "string", Required: true,
+			MinLength: cfg.MinNameLen, MaxLength: cfg.MaxNameLen,
+			ErrorCodes:  []string{errCodeSnippetNameRequired, errCodeSnippetNameTooShort, errCodeSnippetNameTooLong},
+			Description: "length in characters",
+		},
+		{
+			Name: "code", Type: "string", Required: true, MaxBytes: cfg.MaxCodeBytes,
+			ErrorCodes:  []string{errCodeCodeRequired, errCodeCodeTooLarge},
+			Description: "size in bytes of the UTF-8 text, stored as sent unless ?trim=true trims trailing whitespace",
+		},
+		{
+			Name: "language", Type: "string", Enum: languages,
+			ErrorCodes:  []string{errCodeLanguageNotAllowed},
+			Description: "stored lowercase, any language is accepted when there is no enum",
+		},
+		{
+			Name: "tags", Type: "array", Items: "string", MaxItems: cfg.MaxTags, ItemMaxLength: maxTagLen,
+			ErrorCodes:  []string{errCodeTooManyTags, errCodeInvalidTag},
+			Description: "tag length in characters",
+		},
+		{
+			Name: "folder", Type: "string", MaxLength: maxFolderLen,
+			ErrorCodes:  []string{errCodeInvalidFolder},
+			Description: "slash separated path of non-empty segments, empty is the root; changed with POST /id/{codeid}/move",
+		},
+		{
+			Name: "expires_at", Type: "timestamp",
+			ErrorCodes:  []string{errCodeInvalidExpiry},
+			Description: "RFC 3339, must be in the future",
+		},
+		{
+			Name: "max_views", Type: "integer", Minimum: &zero,
+			ErrorCodes:  []string{errCodeInvalidMaxViews},
+			Description: "only taken on create, 0 means no limit",
+		},
+		{
+			Name: "expected_updated_at", Type: "timestamp",
+			Description: "only read by PUT, the update fails with 409 when the snippet changed since",
+		},
+		{Name: "sort_order", Type: "integer", ReadOnly: true, Description: "changed with PUT /id/{codeid}/sort-order"},
+		{Name: "locked", Type: "boolean", ReadOnly: true, Description: "changed with POST /id/{codeid}/lock and /unlock"},
+		{Name: "archived", Type: "boolean", ReadOnly: true, Description: "changed with POST /id/{codeid}/archive and /unarchive"},
+		{Name: "score", Type: "integer", ReadOnly: true, Description: "sum of the votes"},
+		{Name: "share_count", Type: "integer", ReadOnly: true, Description: "number of share links handed out"},
+		{Name: "view_count", Type: "integer", ReadOnly: true, Description: "number of views"},
+		{
+			Name: "source", Type: "string", ReadOnly: true, MaxLength: maxSourceLen,
+			ErrorCodes:  []string{errCodeInvalidSource},
+			Description: "client that created the snippet, from the X-Client-Name header or ?source= on create, default unknown",
+		},
+		{Name: "slug", Type: "string", ReadOnly: true, Description: "set by the server when GENERATE_SLUGS is on"},
+		{Name: "owner", Type: "string", ReadOnly: true, Description: "X-User-ID of the create when SNIPPET_OWNERSHIP is on"},
+		{Name: "url", Type: "string", ReadOnly: true, Description: "canonical link of the snippet"},
+		{
+			Name: "created_at", Type: "timestamp", ReadOnly: true,
+			ErrorCodes:  []string{errCodeInvalidTimestamp},
+			Description: "set by the server, kept from the request with ?preserve_timestamps=true",
+		},
+		{Name: "updated_at", Type: "timestamp", ReadOnly: true, Description: "set by the server"},
+		{Name: "last_accessed_at", Type: "timestamp", ReadOnly: true, Description: "set by the server"},
+	}
+}
+
+// getSnippetSchema returns the snippet fields with their constraints, see snippetSchema. The
+// body limit is part of data, so it is there with ?envelope=false too.
+func getSnippetSchema(w http.ResponseWriter, r *http.Request) {
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": renderer.M{
+			"fields":         snippetSchema(),
+			"max_body_bytes": cfg.MaxBodyBytes,
+		},
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnippetSchemaWithoutEnvelope(t *testing.T) {
+	rec := httptest.NewRecorder()
+	getSnippetSchema(rec, httptest.NewRequest(http.MethodGet, "/schema?envelope=false", nil))
+
+	var body struct {
+		Fields       []schemaField `json:"fields"`
+		MaxBodyBytes *int64        `json:"max_body_bytes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not the schema: %s", rec.Body.String(), err)
+	}
+	if body.MaxBodyBytes == nil || *body.MaxBodyBytes != cfg.MaxBodyBytes {
+		t.Errorf("max_body_bytes = %v, want %d", body.MaxBodyBytes, cfg.MaxBodyBytes)
+	}
+	if len(body.Fields) == 0 || body.Fields[0].Name != "id" {
+		t.Errorf("fields = %+v, want the snippet fields starting with id", body.Fields)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// searchResult is a snippet in the search response, Score is only set for text index searches
+type searchResult struct {
+	CodeSnippet
+	Score *float64 `json:"score,omitempty"`
+}
+
+func (s searchResult) MarshalJSON() ([]byte, error) {
+	return marshalSnippetWith(s.CodeSnippet, struct {
+		Score *float64 `json:"score,omitempty"`
+	}{s.Score})
+}
+
+const (
+	// searchRegexMaxRepeat is the highest count a ?regex=true pattern may use in {n,m}
+	searchRegexMaxRepeat = 100
+	// searchRegexMaxTime bounds the regex search on the server, backing up the checks of parseSearchRegex
+	searchRegexMaxTime = 2 * time.Second
+)
+
+// scoredSnippet decodes a snippet together with the text score projected next to it
+type scoredSnippet struct {
+	CodeSnippetModel `bson:",inline"`
+	Score            float64 `bson:"score"`
+}
+
+/*
+searchSnippets searches names and code for ?q=, paginated with ?limit=&offset=.
+
+	The search uses the snippet_text index ($text), every result carries its relevance score
+	and ?sort=relevance (the default) orders by it, ?sort=-created_at orders newest first.
+	When the text index doesn't exist (yet) the search falls back to a case-insensitive
+	substring match on name and code. Those results have no score and are always sorted newest
+	first. A fallback always treats q as a literal, regex characters in it are escaped.
+	?regex=true opts into a real regular expression instead, it skips the text index, is matched
+	case-insensitively on name and code like the fallback and must pass parseSearchRegex, every
+	pattern that doesn't is a 400 before anything reaches the database. The X-Search-Mode header
+	tells which of the two was used ("text" or "regex"). ?ids_only=true returns only the ids in
+	the same order, see wantIDsOnly. Archived snippets are only found with
+	?include_archived=true.
+*/
+func searchSnippets(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "q must not be empty")
+		return
+	}
+
+	useRegex := queryBool(r, "regex")
+	if useRegex {
+		if err := parseSearchRegex(q); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+			return
+		}
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "relevance" && useRegex {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "sort=relevance needs the text search, regex results are sorted by -created_at")
+		return
+	}
+	if sortBy == "" {
+		sortBy = "relevance"
+	}
+	if sortBy != "relevance" && sortBy != "-created_at" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "sort must be one of: relevance, -created_at")
+		return
+	}
+
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	idsOnly, err := wantIDsOnly(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	var results []searchResult
+	mode := "regex"
+	if useRegex {
+		results, err = regexSearch(r, q, page, idsOnly)
+	} else {
+		results, err = textSearch(r, q, sortBy, page, idsOnly)
+		mode = "text"
+		if isTextIndexMissing(err) {
+			results, err = regexSearch(r, regexp.QuoteMeta(q), page, idsOnly)
+			mode = "regex"
+		}
+	}
+	if isCommandError(err, maxTimeExpiredCode) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "the search took too long, use a simpler pattern")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to search snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to search snippets")
+		return
+	}
+
+	w.Header().Set("X-Search-Mode", mode)
+	if idsOnly {
+		ids := []string{}
+		for _, s := range results {
+			ids = append(ids, s.ID)
+		}
+		respond(w, r, http.StatusOK, renderer.M{
+			"data":       ids,
+			"pagination": page,
+		})
+		return
+	}
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       results,
+		"pagination": page,
+	})
+}
+
+// textSearch runs a $text query and returns the results with their score, only their ids with idsOnly
+func textSearch(r *http.Request, q, sortBy string, page pagination, idsOnly bool) ([]searchResult, error) {
+	score := bson.M{"$meta": "textScore"}
+	sort := bson.D{{Key: "score", Value: score}, {Key: "_id", Value: 1}}
+	if sortBy == "-created_at" {
+		sort = bson.D{{Key: "createAt", Value: -1}, {Key: "_id", Value: 1}}
+	}
+
+	projection := bson.M{"score": score}
+	if idsOnly {
+		projection["_id"] = 1
+	}
+	opts := options.Find().
+		SetProjection(projection).
+		SetSort(sort).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+
+	// $text can't be limited to the name, burn after reading snippets are left out entirely so a
+	// search can't reveal their code without a counted read
+	filter := bson.M{"$text": bson.M{"$search": q}, "maxViews": bson.M{"$exists": false}}
+	cursor, err := db.Collection(collectionName).Find(r.Context(), withoutArchived(r, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []scoredSnippet{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		return nil, err
+	}
+
+	results := []searchResult{}
+	for _, s := range found {
+		score := s.Score
+		results = append(results, searchResult{CodeSnippet: snippetResponse(r, s.CodeSnippetModel), Score: &score})
+	}
+	return results, nil
+}
+
+// regexSearch matches name and code case-insensitively against pattern, either the escaped q of
+// the fallback without a text index or a ?regex=true pattern that passed parseSearchRegex.
+// Burn after reading snippets only match by name, anchored patterns would spell out their code.
+func regexSearch(r *http.Request, expr string, page pagination, idsOnly bool) ([]searchResult, error) {
+	pattern := primitive.Regex{Pattern: expr, Options: "i"}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"snippetname": pattern},
+		bson.M{"code": pattern, "maxViews": bson.M{"$exists": false}},
+	}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit).
+		SetMaxTime(searchRegexMaxTime)
+	if idsOnly {
+		opts.SetProjection(idsOnlyProjection)
+	}
+
+	cursor, err := db.Collection(collectionName).Find(r.Context(), withoutArchived(r, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []CodeSnippetModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		return nil, err
+	}
+
+	results := []searchResult{}
+	for _, s := range found {
+		results = append(results, searchResult{CodeSnippet: snippetResponse(r, s)})
+	}
+	return results, nil
+}
+
+/*
+parseSearchRegex checks a ?regex=true pattern before it is sent to MongoDB.
+
+	MongoDB matches with PCRE, which backtracks, so a pattern like (a+)+$ can take exponential
+	time on a long enough code. The pattern is parsed with Go's (Perl like) syntax, which also
+	refuses backreferences and lookarounds, and then refused when it is longer than
+	SEARCH_REGEX_MAX_LENGTH, nests groups and quantifiers deeper than SEARCH_REGEX_MAX_DEPTH,
+	repeats more than searchRegexMaxRepeat times or puts a quantifier inside a repeated
+	quantifier, the usual shape of catastrophic backtracking.
+*/
+func parseSearchRegex(pattern string) error {
+	if len(pattern) > cfg.SearchRegexMaxLength {
+		return fmt.Errorf("the regex must be at most %d bytes", cfg.SearchRegexMaxLength)
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return fmt.Errorf("invalid regex: %s", err)
+	}
+	if depth := regexDepth(re); depth > cfg.SearchRegexMaxDepth {
+		return fmt.Errorf("the regex nests groups and quantifiers %d deep, at most %d is allowed", depth, cfg.SearchRegexMaxDepth)
+	}
+	return checkRegexRepeats(re, false)
+}
+
+// regexDepth is how deep groups and quantifiers are nested in re, a pattern without any is 0
+func regexDepth(re *syntax.Regexp) int {
+	deepest := 0
+	for _, sub := range re.Sub {
+		if d := regexDepth(sub); d > deepest {
+			deepest = d
+		}
+	}
+	switch re.Op {
+	case syntax.OpCapture, syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		return deepest + 1
+	}
+	return deepest
+}
+
+// checkRegexRepeats refuses bounded repeats above searchRegexMaxRepeat and quantifiers nested in
+// a quantifier that repeats (inRepeat), ? and {0,1} don't repeat and may hold or be held by anything
+func checkRegexRepeats(re *syntax.Regexp, inRepeat bool) error {
+	repeats := false
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		repeats = true
+	case syntax.OpRepeat:
+		if re.Max > searchRegexMaxRepeat || re.Min > searchRegexMaxRepeat {
+			return fmt.Errorf("the regex repeats more than %d times", searchRegexMaxRepeat)
+		}
+		repeats = re.Max == -1 || re.Max > 1
+	}
+	if repeats && inRepeat {
+		return errors.New("the regex nests repeated quantifiers (like (a+)+), which can take exponential time")
+	}
+	for _, sub := range re.Sub {
+		if err := checkRegexRepeats(sub, inRepeat || repeats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTextIndexMissing reports whether a $text query failed because the collection has no text index
+func isTextIndexMissing(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		// 27 = IndexNotFound, "text index required for $text query"
+		return cmdErr.Code == 27
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// defaultSeedFixtures are the sample snippets POST /admin/seed inserts unless SEED_FILE points elsewhere
+//
+//go:embed fixtures/snippets.json
+var defaultSeedFixtures []byte
+
+/*
+seedCollection fills the collection with sample snippets for demos and local development.
+
+	It must be turned on with ALLOW_ADMIN_SEED=true. The fixtures are a JSON array of snippets
+	in the request format of POST /code-snippets, the embedded fixtures/snippets.json unless
+	SEED_FILE names another file. Every fixture is validated like a create request first, one bad
+	fixture fails the whole seed. A collection that already has snippets is refused with 409
+	unless ?force=true, in which case the fixtures are added next to the existing snippets.
+*/
+func seedCollection(w http.ResponseWriter, r *http.Request) {
+	if !cfg.AllowAdminSeed {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "seeding is disabled, set ALLOW_ADMIN_SEED=true to enable it")
+		return
+	}
+
+	fixtures, err := loadSeedFixtures()
+	if err != nil {
+		log.Printf("failed to load seed fixtures: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to load the seed fixtures")
+		return
+	}
+
+	if !queryBool(r, "force") {
+		n, err := db.Collection(collectionName).EstimatedDocumentCount(r.Context())
+		if err != nil {
+			log.Printf("failed to count snippets: %s\n", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to count snippets")
+			return
+		}
+		if n > 0 {
+			writeError(w, http.StatusConflict, errCodeConflict, fmt.Sprintf("the collection already has %d snippets, use ?force=true to seed anyway", n))
+			return
+		}
+	}
+
+	models := make([]CodeSnippetModel, 0, len(fixtures))
+	for i, c := range fixtures {
+		if errs := validateSnippet(c); len(errs) > 0 {
+			log.Printf("seed fixture %d (%q) is invalid: %s\n", i, c.SnippetName, errs[0].Message)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("seed fixture %d is invalid: %s", i, errs[0].Message))
+			return
+		}
+		models = append(models, newSnippetModel(c))
+	}
+	if err := assignBatchSlugs(r.Context(), models); err != nil {
+		log.Printf("failed to pick slugs for the seed snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to insert the seed snippets")
+		return
+	}
+	docs := make([]interface{}, len(models))
+	for i := range models {
+		docs[i] = &models[i]
+	}
+
+	inserted := 0
+	if len(docs) > 0 {
+		result, err := db.Collection(collectionName).InsertMany(r.Context(), docs)
+		if result != nil {
+			inserted = len(result.InsertedIDs)
+		}
+		// with ?force=true a fixture's name may already be taken, the ordered insert stops there
+		if isDuplicateKey(err) {
+			writeError(w, http.StatusConflict, errCodeDuplicateName, fmt.Sprintf("a seed snippet's name is already taken, %d snippets were inserted before it", inserted))
+			return
+		}
+		if err != nil {
+			log.Printf("failed to seed snippets (%d inserted): %s\n", inserted, err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to insert the seed snippets")
+			return
+		}
+	}
+
+	log.Printf("admin seed inserted %d snippets\n", inserted)
+
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message":  "Collection seeded successfully",
+		"inserted": inserted,
+	})
+}
+
+// loadSeedFixtures reads SEED_FILE if it is set, the embedded fixtures otherwise
+func loadSeedFixtures() ([]CodeSnippet, error) {
+	data, source := defaultSeedFixtures, "fixtures/snippets.json"
+	if cfg.SeedFile != "" {
+		source = cfg.SeedFile
+		var err error
+		if data, err = os.ReadFile(cfg.SeedFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var fixtures []CodeSnippet
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return fixtures, nil
+}
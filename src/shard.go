@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// shardKeyHeader carries the shard key value of the snippet a request is about
+const shardKeyHeader = "X-Shard-Key"
+
+/*
+withShardKey adds the shard key to a single snippet filter when SHARD_KEY is configured.
+
+	On a sharded collection a filter on _id or snippetname alone is sent to every shard
+	(scatter-gather). When the client tells us the shard key value in the X-Shard-Key header
+	the filter also matches on that field, so mongos routes the query to the one shard holding it.
+	Without the config or the header the filter is returned unchanged and behaves as before.
+	The field is only used for routing, documents are expected to already carry it.
+*/
+func withShardKey(r *http.Request, filter bson.M) bson.M {
+	if cfg.ShardKey == "" {
+		return filter
+	}
+	value := strings.TrimSpace(r.Header.Get(shardKeyHeader))
+	if value == "" {
+		return filter
+	}
+	filter[cfg.ShardKey] = value
+	return filter
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// shareCountTimeout bounds the share counter update, a slow database shouldn't hold up the read
+const shareCountTimeout = 2 * time.Second
+
+// sharedSnippet is the shared snippet together with whether this share was counted
+type sharedSnippet struct {
+	CodeSnippet
+	Counted bool `json:"counted"`
+}
+
+func (s sharedSnippet) MarshalJSON() ([]byte, error) {
+	return marshalSnippetWith(s.CodeSnippet, struct {
+		Counted bool `json:"counted"`
+	}{s.Counted})
+}
+
+/*
+shareSnippet returns a snippet for sharing and counts the share (GET /id/{codeid}/share).
+
+	The $inc and the read are one FindOneAndUpdate, so concurrent shares are all counted and
+	each response carries the count including its own share. The update has its own short
+	timeout; when it fails the snippet is still returned from a plain read, with "counted":
+	false and the count as it was. Read-only mode skips the update the same way. Both are fields
+	of data (share_count is the snippet's own), so they survive ?envelope=false. Shares are counted on locked snippets too, the lock only
+	protects the content.
+*/
+func shareSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), shareCountTimeout)
+	defer cancel()
+
+	var shared CodeSnippetModel
+	var err error
+	counted := !readOnly.Load()
+	if counted {
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = db.Collection(collectionName).FindOneAndUpdate(ctx, withShardKey(r, bson.M{"_id": id}), bson.M{"$inc": bson.M{"shareCount": 1}}, opts).Decode(&shared)
+		if err == nil {
+			snippetCache.invalidate(id)
+		} else if err != mongo.ErrNoDocuments {
+			log.Printf("failed to count share of snippet %s: %s\n", id.Hex(), err)
+			counted = false
+		}
+	}
+	if !counted {
+		err = db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"_id": id})).Decode(&shared)
+	}
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+
+	touchSnippet(shared)
+	writeSharedSnippet(w, r, shared, counted)
+}
+
+// writeSharedSnippet sends the response of a share, counted tells whether the share was counted
+func writeSharedSnippet(w http.ResponseWriter, r *http.Request, shared CodeSnippetModel, counted bool) {
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": sharedSnippet{CodeSnippet: snippetResponse(r, shared), Counted: counted},
+	})
+}
+
+// getMostSharedSnippets lists the snippets shared at least once, most shared first, paginated like getAllSnippets
+func getMostSharedSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "shareCount", Value: -1}, {Key: "createAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+	cursor, err := db.Collection(collectionName).Find(r.Context(), withoutArchived(r, bson.M{"shareCount": bson.M{"$gt": 0}}), opts)
+	if err != nil {
+		log.Printf("failed to fetch most shared snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	found := []CodeSnippetModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch most shared snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+
+	snippets := []CodeSnippet{}
+	for _, s := range found {
+		snippets = append(snippets, snippetResponse(r, s))
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       snippets,
+		"pagination": page,
+	})
+}
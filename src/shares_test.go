@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestSharedSnippetResponse checks that share_count and counted are in the snippet with and without the envelope
+func TestSharedSnippetResponse(t *testing.T) {
+	shared := newSnippetModel(CodeSnippet{SnippetName: "hello", Code: "x"})
+	shared.ID = primitive.NewObjectID()
+	shared.ShareCount = 3
+
+	for _, path := range []string{"/share?envelope=true", "/share?envelope=false"} {
+		rec := httptest.NewRecorder()
+		writeSharedSnippet(rec, httptest.NewRequest(http.MethodGet, path, nil), shared, false)
+
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: response %q is not an object: %s", path, rec.Body.String(), err)
+		}
+		snippet := body
+		if data, ok := body["data"]; ok {
+			if err := json.Unmarshal(data, &snippet); err != nil {
+				t.Fatalf("%s: data %s is not an object: %s", path, data, err)
+			}
+		}
+		if got := string(snippet["share_count"]); got != "3" {
+			t.Errorf("%s: share_count = %s, want 3", path, got)
+		}
+		if got := string(snippet["counted"]); got != "false" {
+			t.Errorf("%s: counted = %s, want false", path, got)
+		}
+		if got := string(snippet["snippet_name"]); got != `"hello"` {
+			t.Errorf("%s: snippet_name = %s, want \"hello\"", path, got)
+		}
+	}
+}
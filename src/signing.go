@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// signatureHeader carries the HMAC of a signed response, e.g. X-Signature: sha256=3f1a...
+	signatureHeader = "X-Signature"
+	// minSigningKeyLen is the shortest RESPONSE_SIGNING_KEY accepted, the size of the SHA-256 output
+	minSigningKeyLen = 32
+)
+
+/*
+signResponses adds an HMAC signature of the body to responses of requests with ?sign=true.
+
+	The signature is HMAC-SHA256 keyed with RESPONSE_SIGNING_KEY, sent hex encoded in
+	X-Signature as "sha256=<hex>". There is no canonicalization: it covers the body bytes
+	exactly as sent by the server, with whatever envelope, YAML or JSONP formatting the request
+	chose. A client verifies by computing the same HMAC over the bytes it received (after
+	undoing any Content-Encoding a proxy added) and comparing in constant time, re-encoding the
+	JSON before verifying breaks the signature. Headers, status and URL are not covered.
+
+	The response is buffered to compute the signature, so NDJSON streaming can't be signed and
+	is refused, as is ?sign=true while no key is configured. 304s have no body and no signature,
+	the client keeps the signature of the response it cached.
+*/
+func signResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !queryBool(r, "sign") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cfg.ResponseSigningKey == "" {
+			writeError(w, http.StatusNotImplemented, errCodeNotImplemented, "response signing is not configured on this server")
+			return
+		}
+		if wantNDJSON(r) {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "sign can't be combined with a streamed NDJSON response")
+			return
+		}
+
+		sw := &signingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		body := sw.body.Bytes()
+		if sw.status != http.StatusNotModified {
+			w.Header().Set(signatureHeader, "sha256="+signBody(body))
+			// a handler that set its own length (e.g. http.ServeContent) already knows better
+			if w.Header().Get("Content-Length") == "" {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+		}
+		w.WriteHeader(sw.status)
+		w.Write(body)
+	})
+}
+
+// signBody returns the hex encoded HMAC-SHA256 of body under RESPONSE_SIGNING_KEY
+func signBody(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(cfg.ResponseSigningKey))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingWriter holds back the status and body of a signed response until the handler is done
+type signingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (sw *signingWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		sw.status = status
+	}
+}
+
+func (sw *signingWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.body.Write(b)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxSlugLen is the longest slug generated, the counter of a collision comes on top
+	maxSlugLen = 80
+	// slugIndexName is the unique index on slug, how a duplicate key error is told apart from others
+	slugIndexName = "slug_1"
+	// slugInsertAttempts is how often a create picks the next free slug after losing a race for one
+	slugInsertAttempts = 5
+)
+
+// slugify turns a snippet name into a URL friendly slug: lowercase letters and digits, every
+// other run of characters becomes one hyphen, e.g. "HTTP Client (Go)" is "http-client-go".
+// A name without any letter or digit gives "snippet".
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		hyphen = true
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLen {
+		// cut on a rune boundary and don't end on a hyphen
+		slug = strings.TrimRight(strings.ToValidUTF8(slug[:maxSlugLen], ""), "-")
+	}
+	if slug == "" {
+		return "snippet"
+	}
+	return slug
+}
+
+// nextFreeSlug returns base when no snippet has it yet, otherwise base-2, base-3, ... the first
+// free one. Only base and base-<n> are loaded, the anchored regex is served by the slug index.
+// Slugs in reserved count as taken too, they are handed out to a batch not inserted yet.
+func nextFreeSlug(ctx context.Context, base string, reserved map[string]bool) (string, error) {
+	filter := bson.M{"slug": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(base) + `(-\d+)?$`}}
+	cursor, err := db.Collection(collectionName).Find(ctx, filter, options.Find().SetProjection(bson.M{"slug": 1}))
+	if err != nil {
+		return "", err
+	}
+	taken := []CodeSnippetModel{}
+	if err := cursor.All(ctx, &taken); err != nil {
+		return "", err
+	}
+
+	used := map[string]bool{}
+	for slug := range reserved {
+		used[slug] = true
+	}
+	for _, s := range taken {
+		used[s.Slug] = true
+	}
+	if !used[base] {
+		return base, nil
+	}
+	for n := 2; ; n++ {
+		if slug := base + "-" + strconv.Itoa(n); !used[slug] {
+			return slug, nil
+		}
+	}
+}
+
+// isDuplicateSlug reports whether err is a duplicate key error of the slug index
+func isDuplicateSlug(err error) bool {
+	return isDuplicateKey(err) && strings.Contains(err.Error(), slugIndexName)
+}
+
+/*
+insertWithSlug gives cm the first free slug of its name and runs insert.
+
+	Two creates of the same name can pick the same free slug, the unique index then lets
+	only one of them in. The other gets the next free slug and inserts again, at most
+	slugInsertAttempts times, any other error is returned as is.
+*/
+func insertWithSlug(ctx context.Context, cm *CodeSnippetModel, insert func() error) error {
+	base := slugify(cm.SnippetName)
+	for attempt := 1; ; attempt++ {
+		slug, err := nextFreeSlug(ctx, base, nil)
+		if err != nil {
+			return err
+		}
+		cm.Slug = slug
+		if err = insert(); !isDuplicateSlug(err) || attempt == slugInsertAttempts {
+			return err
+		}
+	}
+}
+
+// getSnippetBySlug returns the snippet with the slug, answering like a lookup by name
+func getSnippetBySlug(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimSpace(chi.URLParam(r, "slug"))
+
+	var foundSnippet CodeSnippetModel
+	err := db.Collection(collectionName).FindOne(r.Context(), withShardKey(r, bson.M{"slug": slug})).Decode(&foundSnippet)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to fetch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippet")
+		return
+	}
+
+	touchSnippet(foundSnippet)
+	if notModified(w, r, foundSnippet.lastModified()) {
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": snippetResponse(r, foundSnippet),
+	})
+}
+
+// insertNewSnippet runs the insert of a create, with a generated slug when GENERATE_SLUGS is on
+func insertNewSnippet(ctx context.Context, cm *CodeSnippetModel, insert func() error) error {
+	if !cfg.GenerateSlugs {
+		return insert()
+	}
+	return insertWithSlug(ctx, cm, insert)
+}
+
+// assignBatchSlugs gives every model of a batch insert a free slug when GENERATE_SLUGS is on,
+// two snippets of the batch with the same name get different ones
+func assignBatchSlugs(ctx context.Context, models []CodeSnippetModel) error {
+	if !cfg.GenerateSlugs {
+		return nil
+	}
+	reserved := map[string]bool{}
+	for i := range models {
+		slug, err := nextFreeSlug(ctx, slugify(models[i].SnippetName), reserved)
+		if err != nil {
+			return err
+		}
+		models[i].Slug = slug
+		reserved[slug] = true
+	}
+	return nil
+}
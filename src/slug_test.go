@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"HTTP Client (Go)", "http-client-go"},
+		{"  leading and trailing  ", "leading-and-trailing"},
+		{"Grüße", "grüße"},
+		{"!!!", "snippet"},
+		{"", "snippet"},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.name); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestBatchSlugs covers bulk create and import, both insert through insertSnippetsUnordered
+func TestBatchSlugs(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	oldGenerate := cfg.GenerateSlugs
+	cfg.GenerateSlugs = true
+	t.Cleanup(func() { cfg.GenerateSlugs = oldGenerate })
+
+	ensureCollections()
+	if failed := ensureIndexes(); failed != 0 {
+		t.Fatalf("%d indexes failed", failed)
+	}
+	existing := newSnippetModel(CodeSnippet{SnippetName: "Hello", Code: "a"})
+	existing.Slug = "hello-world"
+	if _, err := db.Collection(collectionName).InsertOne(ctx, &existing); err != nil {
+		t.Fatalf("failed to insert the existing snippet: %s", err)
+	}
+
+	models := []CodeSnippetModel{
+		newSnippetModel(CodeSnippet{SnippetName: "Hello World", Code: "b"}),
+		newSnippetModel(CodeSnippet{SnippetName: "hello world!", Code: "c"}),
+	}
+	failed, err := insertSnippetsUnordered(ctx, models)
+	if err != nil || len(failed) > 0 {
+		t.Fatalf("insertSnippetsUnordered = %v, %v", failed, err)
+	}
+	for i, want := range []string{"hello-world-2", "hello-world-3"} {
+		var got CodeSnippetModel
+		if err := db.Collection(collectionName).FindOne(ctx, bson.M{"_id": models[i].ID}).Decode(&got); err != nil {
+			t.Fatalf("failed to find %s: %s", models[i].ID.Hex(), err)
+		}
+		if got.Slug != want {
+			t.Errorf("snippet %q has slug %q, want %q", got.SnippetName, got.Slug, want)
+		}
+	}
+}
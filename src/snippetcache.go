@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+snippetLRU keeps the most recently read snippets in memory for getSnippetByID.
+
+	Every handler that changes a snippet calls invalidate(id) after the write, so the next read
+	goes back to Mongo. A read that missed can race a write: it loads the old document, the write
+	invalidates, and then the read would cache the old document. To prevent that every invalidation
+	bumps epoch, a read takes the epoch before querying and put drops the document if it changed.
+	The cache is per process, writes made by other instances (or directly in Mongo) are only
+	seen once the entry is evicted, so keep SNIPPET_CACHE_SIZE at 0 when running several instances.
+*/
+type snippetLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used
+	entries map[primitive.ObjectID]*list.Element
+	epoch   uint64
+}
+
+type snippetLRUEntry struct {
+	id      primitive.ObjectID
+	snippet CodeSnippetModel
+}
+
+// snippetCache is nil when SNIPPET_CACHE_SIZE is 0, every method is a no-op on a nil cache
+var snippetCache *snippetLRU
+
+func newSnippetLRU(size int) *snippetLRU {
+	if size <= 0 {
+		return nil
+	}
+	return &snippetLRU{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[primitive.ObjectID]*list.Element),
+	}
+}
+
+// get returns the cached snippet and the current epoch to hand to put after a miss
+func (c *snippetLRU) get(id primitive.ObjectID) (CodeSnippetModel, uint64, bool) {
+	if c == nil {
+		return CodeSnippetModel{}, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return CodeSnippetModel{}, c.epoch, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*snippetLRUEntry).snippet, c.epoch, true
+}
+
+// put caches a snippet loaded from Mongo, unless something was invalidated since epoch was read
+func (c *snippetLRU) put(s CodeSnippetModel, epoch uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if epoch != c.epoch {
+		return
+	}
+	if el, ok := c.entries[s.ID]; ok {
+		el.Value.(*snippetLRUEntry).snippet = s
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[s.ID] = c.order.PushFront(&snippetLRUEntry{id: s.ID, snippet: s})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*snippetLRUEntry).id)
+	}
+}
+
+// invalidate drops the cached copies of the given snippets
+func (c *snippetLRU) invalidate(ids ...primitive.ObjectID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.order.Remove(el)
+			delete(c.entries, id)
+		}
+	}
+}
+
+// purge empties the cache, used when the whole collection is cleared
+func (c *snippetLRU) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	c.order.Init()
+	c.entries = make(map[primitive.ObjectID]*list.Element)
+}
+
+// touched records a lastAccessedAt write on the cached copy, so hits keep honouring accessThrottle
+func (c *snippetLRU) touched(id primitive.ObjectID, at time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		el.Value.(*snippetLRUEntry).snippet.LastAccessedAt = &at
+	}
+}
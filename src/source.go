@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// defaultSource is the source of a snippet created without X-Client-Name or ?source=,
+	// and of the snippets stored before the source was recorded
+	defaultSource = "unknown"
+	maxSourceLen  = 50
+)
+
+// sourcePattern is what a source may look like once lowercased, e.g. "vscode-ext" or "cli.v2"
+var sourcePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// validSource normalizes a client name (trimmed, lowercase so "Web" and "web" group together)
+// and reports whether it is allowed
+func validSource(source string) (string, bool) {
+	source = strings.ToLower(strings.TrimSpace(source))
+	return source, len(source) <= maxSourceLen && sourcePattern.MatchString(source)
+}
+
+/*
+requestSource returns the client that creates snippets with this request, for provenance.
+
+	It is taken from the X-Client-Name header, or ?source= for clients that can't set headers,
+	and is defaultSource when neither is there. An invalid name is refused with a 400 (written
+	here, like snippetIDParam) rather than stored as unknown, a misconfigured client should notice.
+	A "source" in the body is never used, the request is what identifies the client.
+*/
+func requestSource(w http.ResponseWriter, r *http.Request) (string, bool) {
+	raw := r.Header.Get("X-Client-Name")
+	if raw == "" {
+		raw = r.URL.Query().Get("source")
+	}
+	if strings.TrimSpace(raw) == "" {
+		return defaultSource, true
+	}
+	source, ok := validSource(raw)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errCodeInvalidSource,
+			fmt.Sprintf("the client name must be at most %d letters, digits, '.', '_' or '-'", maxSourceLen))
+		return "", false
+	}
+	return source, true
+}
+
+// sourceFilter matches the snippets created by source, "unknown" also matches the
+// snippets stored before the source was recorded
+func sourceFilter(source string) bson.M {
+	if source == defaultSource {
+		return bson.M{"source": bson.M{"$in": bson.A{defaultSource, nil}}}
+	}
+	return bson.M{"source": source}
+}
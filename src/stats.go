@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// languageStats is one row of the /stats response, sizes are in bytes
+type languageStats struct {
+	Language   string  `bson:"_id" json:"language"`
+	Count      int64   `bson:"count" json:"count"`
+	TotalBytes int64   `bson:"totalBytes" json:"total_bytes"`
+	AvgBytes   float64 `bson:"avgBytes" json:"avg_bytes"`
+}
+
+// codeBytesExpr is the uncompressed code size of a document: the stored codeBytes, or the
+// length of the plain code for snippets written before codeBytes existed
+var codeBytesExpr = bson.M{"$ifNull": bson.A{"$codeBytes", bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$code", ""}}}}}
+
+/*
+getSnippetStats returns the number of snippets and the total and average code size per language.
+
+	The size is the stored codeBytes (the uncompressed size), for older snippets without it the
+	length of the code is computed with $strLenBytes inside the aggregation. Snippets stored
+	before languages existed are grouped under "". Results are sorted by total size, biggest first.
+*/
+func getSnippetStats(w http.ResponseWriter, r *http.Request) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":        bson.M{"$ifNull": bson.A{"$language", ""}},
+			"count":      bson.M{"$sum": 1},
+			"totalBytes": bson.M{"$sum": codeBytesExpr},
+			"avgBytes":   bson.M{"$avg": codeBytesExpr},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totalBytes", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := db.Collection(collectionName).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("failed to compute stats: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to compute stats")
+		return
+	}
+
+	stats := []languageStats{}
+	if err := cursor.All(r.Context(), &stats); err != nil {
+		log.Printf("failed to compute stats: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to compute stats")
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": stats,
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// statusHandler reports the runtime state of the service for operators and monitoring
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	state, failures := dbBreaker.snapshot()
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"service":        serviceName,
+		"version":        serviceVersion,
+		"read_only":      readOnly.Load(),
+		"in_flight":      inFlight.Load(),
+		"max_concurrent": cfg.MaxConcurrent,
+		"pagination": renderer.M{
+			"default_page_size": cfg.DefaultPageSize,
+			"max_page_size":     cfg.MaxPageSize,
+		},
+		// empty means the connection string's setting is used
+		"mongo": renderer.M{
+			"read_preference": cfg.MongoReadPreference,
+			"write_concern":   cfg.MongoWriteConcern,
+		},
+		"database_breaker": renderer.M{
+			"state":                state,
+			"consecutive_failures": failures,
+		},
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// nameSuggestionProbes is how many numbered names (name-2, name-3, ...) are checked at most
+	nameSuggestionProbes = 5
+	// nameSuggestionCount is how many free names are returned at most
+	nameSuggestionCount = 3
+)
+
+/*
+suggestSnippetNames returns up to nameSuggestionCount free alternatives for a taken name.
+
+	The candidates name-2 ... name-6 are checked with a single $in query, so the probe costs one
+	round trip whatever the outcome. The base is shortened when a suffix would push the name past
+	MAX_NAME_LEN. A free name can still be taken by someone else before the client retries with it.
+	Errors only cost the suggestions, the caller still sends its 409.
+*/
+func suggestSnippetNames(ctx context.Context, name string) []string {
+	suggestions := []string{}
+
+	candidates := make([]string, 0, nameSuggestionProbes)
+	for n := 2; n < 2+nameSuggestionProbes; n++ {
+		candidate, ok := numberedName(name, n)
+		if !ok {
+			return suggestions
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	opts := options.Find().SetProjection(bson.M{"snippetname": 1})
+	cursor, err := db.Collection(collectionName).Find(ctx, bson.M{"snippetname": bson.M{"$in": candidates}}, opts)
+	if err != nil {
+		log.Printf("failed to look up name suggestions: %s\n", err)
+		return suggestions
+	}
+	taken := []CodeSnippetModel{}
+	if err := cursor.All(ctx, &taken); err != nil {
+		log.Printf("failed to look up name suggestions: %s\n", err)
+		return suggestions
+	}
+
+	used := map[string]bool{}
+	for _, s := range taken {
+		used[s.SnippetName] = true
+	}
+	for _, candidate := range candidates {
+		if !used[candidate] && len(suggestions) < nameSuggestionCount {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
+
+// writeNameTaken sends the 409 for a name another snippet already has, with free names to retry with (createSnippet, renameSnippet)
+func writeNameTaken(w http.ResponseWriter, r *http.Request, name string) {
+	rnd.JSON(w, http.StatusConflict, renderer.M{
+		"code":        errCodeDuplicateName,
+		"message":     fmt.Sprintf("a snippet named %q already exists", name),
+		"suggestions": suggestSnippetNames(r.Context(), name),
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxTagLen is the longest tag accepted by the tag endpoints
+	maxTagLen = 50
+	// tagFacetDefaultLimit is the number of tags GET /tags returns when ?limit= is not given
+	tagFacetDefaultLimit = 50
+	// tagFacetMaxLimit caps ?limit=, a tag cloud with more tags than this isn't readable anyway
+	tagFacetMaxLimit = 500
+)
+
+// tagCount is one row of the tags facet
+type tagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// validTag trims a tag and reports whether it is acceptable
+func validTag(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	n := utf8.RuneCountInString(tag)
+	return tag, n > 0 && n <= maxTagLen
+}
+
+/*
+addSnippetTag adds one tag to a snippet, body: {"tag": "http"}.
+
+	$addToSet makes this atomic and idempotent: adding a tag that is already there changes
+	nothing and still succeeds. The MAX_TAGS limit is part of the filter, so a snippet that
+	already has the maximum number of tags doesn't match unless it already carries the tag.
+*/
+func addSnippetTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Tag string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"tag": "..."}`)
+		return
+	}
+	tag, ok := validTag(body.Tag)
+	if !ok {
+		writeError(w, http.StatusBadRequest, errCodeInvalidTag, fmt.Sprintf("a tag must be between 1 and %d characters", maxTagLen))
+		return
+	}
+
+	filter := withShardKey(r, bson.M{
+		"_id":    id,
+		"locked": notLocked,
+		"$or": bson.A{
+			bson.M{"tags": tag},
+			bson.M{"$expr": bson.M{"$lt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}}, cfg.MaxTags}}},
+		},
+	})
+	update := bson.M{"$addToSet": bson.M{"tags": tag}, "$set": bson.M{"updatedAt": time.Now()}}
+
+	updated, err := updateSnippetTags(r, filter, update)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		// the snippet doesn't exist, is locked or is full, find out which
+		locked, lerr := snippetLocked(r, id)
+		if lerr == nil && locked {
+			writeLocked(w)
+			return
+		}
+		if lerr == nil {
+			writeError(w, http.StatusBadRequest, errCodeTooManyTags, fmt.Sprintf("a snippet can have at most %d tags", cfg.MaxTags))
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to add tag: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to add tag")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"tags": tagsOrEmpty(updated.Tags),
+	})
+}
+
+// removeSnippetTag removes one tag with $pull, removing a tag the snippet doesn't have is a no-op
+func removeSnippetTag(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	tag := strings.TrimSpace(chi.URLParam(r, "tag"))
+
+	filter := withShardKey(r, bson.M{"_id": id, "locked": notLocked})
+	updated, err := updateSnippetTags(r, filter, bson.M{"$pull": bson.M{"tags": tag}, "$set": bson.M{"updatedAt": time.Now()}})
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		if locked, _ := snippetLocked(r, id); locked {
+			writeLocked(w)
+			return
+		}
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to remove tag: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to remove tag")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"tags": tagsOrEmpty(updated.Tags),
+	})
+}
+
+// updateSnippetTags applies a tag update and returns the snippet with only its tags loaded
+func updateSnippetTags(r *http.Request, filter, update bson.M) (CodeSnippetModel, error) {
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"tags": 1})
+
+	var updated CodeSnippetModel
+	err := db.Collection(collectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&updated)
+	return updated, err
+}
+
+// tagsOrEmpty makes sure a snippet without tags is sent as [] instead of null
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+/*
+getTagFacet returns the tags in use with the number of snippets carrying each (GET /tags).
+
+	The tags are sorted by count, most used first (alphabetically among equal counts). ?limit=
+	(default 50, at most 500) caps the list and ?min_count= drops tags used by fewer snippets,
+	both are stages of the aggregation, so only the returned rows leave the database.
+*/
+func getTagFacet(w http.ResponseWriter, r *http.Request) {
+	limit := tagFacetDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "limit must be a positive integer")
+			return
+		}
+		if n < tagFacetMaxLimit {
+			limit = n
+		} else {
+			limit = tagFacetMaxLimit
+		}
+	}
+	minCount := 1
+	if v := r.URL.Query().Get("min_count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "min_count must be a positive integer")
+			return
+		}
+		minCount = n
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gte": minCount}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cursor, err := db.Collection(collectionName).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		log.Printf("failed to count tags: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to count tags")
+		return
+	}
+	tags := []tagCount{}
+	if err := cursor.All(r.Context(), &tags); err != nil {
+		log.Printf("failed to count tags: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to count tags")
+		return
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data": tags,
+	})
+}
+
+/*
+renameTag replaces a tag on every snippet carrying it, POST /tags/rename with {"from": "golang", "to": "go"}.
+
+	One UpdateMany with an update pipeline rewrites the tags array: from becomes to in place
+	and a snippet that already had to keeps it only once, so the order of the other tags and
+	the MAX_TAGS limit are preserved. Locked snippets are skipped like in batchUpdateSnippets.
+	The response has the number of snippets that were renamed.
+*/
+func renameTag(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, r, err, `the request body must be {"from": "...", "to": "..."}`)
+		return
+	}
+	from, fromOK := validTag(body.From)
+	to, toOK := validTag(body.To)
+	if !fromOK || !toOK {
+		writeError(w, http.StatusBadRequest, errCodeInvalidTag, fmt.Sprintf("a tag must be between 1 and %d characters", maxTagLen))
+		return
+	}
+	if from == to {
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "from and to must be different tags")
+		return
+	}
+
+	// $reduce walks the tags in order, mapping from to to and dropping a tag already collected
+	renamed := bson.M{"$reduce": bson.M{
+		"input":        "$tags",
+		"initialValue": bson.A{},
+		"in": bson.M{"$let": bson.M{
+			"vars": bson.M{"tag": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$$this", from}}, to, "$$this"}}},
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$in": bson.A{"$$tag", "$$value"}},
+				"$$value",
+				bson.M{"$concatArrays": bson.A{"$$value", bson.A{"$$tag"}}},
+			}},
+		}},
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tags": renamed, "updatedAt": time.Now()}}},
+	}
+
+	filter := withShardKey(r, bson.M{"tags": from, "locked": notLocked})
+	result, err := db.Collection(collectionName).UpdateMany(r.Context(), filter, update)
+	// which snippets changed isn't known here, the whole cache goes
+	snippetCache.purge()
+	if err != nil {
+		log.Printf("failed to rename tag: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to rename tag")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"message":  "Tag renamed successfully",
+		"from":     from,
+		"to":       to,
+		"modified": result.ModifiedCount,
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+/*
+requestTimeout gives every request an overall deadline of timeout, 0 disables it.
+
+	The request context is cancelled when the deadline passes, so the Mongo operation the handler
+	is waiting on aborts. Whatever the handler answers after that (usually a 500 for the aborted
+	operation) is replaced by a 503 REQUEST_TIMEOUT, a response already started is left alone.
+	Streaming endpoints (export, archive, import, the WebSocket watcher) don't use it, their
+	duration depends on the size of the data rather than on a slow database. For the same
+	reason a list streamed as NDJSON (Accept: application/x-ndjson) has no deadline either.
+*/
+func requestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wantNDJSON(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx, timeout: timeout}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			// the handler gave up without answering
+			if !tw.wroteHeader && ctx.Err() == context.DeadlineExceeded {
+				tw.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// timeoutWriter swaps the response of a handler that answers after the deadline for the 503
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	timeout     time.Duration
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	if tw.ctx.Err() != context.DeadlineExceeded {
+		tw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	tw.timedOut = true
+	// the handler may have described a body that is never sent
+	tw.Header().Del("Content-Length")
+	writeError(tw.ResponseWriter, http.StatusServiceUnavailable, errCodeRequestTimeout,
+		"the request took longer than "+tw.timeout.String()+" and was cancelled")
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		// the handler's body is dropped, it looks written so the handler finishes normally
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
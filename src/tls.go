@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tlsEnabled reports whether the server should serve HTTPS, loadConfig makes sure both files are set or neither
+func tlsEnabled() bool {
+	return cfg.TLSCert != "" && cfg.TLSKey != ""
+}
+
+/*
+startRedirectServer listens on addr (HTTP_REDIRECT_ADDR) and sends every request to the same
+path on the HTTPS port with a 308, so clients using plain http:// end up on https://.
+
+	308 rather than 301 keeps the method and body of a POST or PUT. The returned server is shut
+	down together with the main one.
+*/
+func startRedirectServer(addr string) *http.Server {
+	redirect := &http.Server{
+		Addr:         addr,
+		Handler:      http.HandlerFunc(redirectToHTTPS),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Redirecting http on %s to https on %s\n", addr, port)
+		if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("redirect listen: %s\n", err)
+		}
+	}()
+	return redirect
+}
+
+// redirectToHTTPS rebuilds the request URL with the https scheme and the port of the main server
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if _, httpsPort, err := net.SplitHostPort(port); err == nil && httpsPort != "443" {
+		host = net.JoinHostPort(host, httpsPort)
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
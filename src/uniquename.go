@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// nameIndexName is the unique index on snippetname, the duplicate key errors of creates and
+	// renames come from it (see isDuplicateKey)
+	nameIndexName = "snippetname_1"
+	// maxDedupeProbes is how many numbered names a duplicate tries before it is left as it is
+	maxDedupeProbes = 1000
+)
+
+// numberedName is name with the suffix -n, the base shortened so the result fits MAX_NAME_LEN.
+// It reports false when not even one character of the base fits.
+func numberedName(name string, n int) (string, bool) {
+	suffix := fmt.Sprintf("-%d", n)
+	base := []rune(name)
+	if max := cfg.MaxNameLen - utf8.RuneCountInString(suffix); len(base) > max {
+		if max < 1 {
+			return "", false
+		}
+		base = base[:max]
+	}
+	return string(base) + suffix, true
+}
+
+/*
+prepareUniqueNameIndex gets the collection ready for the unique snippetname_1 index, ensureIndexes
+calls it before creating the indexes.
+
+	Older deployments have snippetname_1 without the unique option and may hold snippets that
+	share a name, building the unique index would fail on them. As long as the index isn't unique
+	the duplicates are renamed first: the oldest snippet keeps the name, the others get the first
+	free numbered name (name-2, name-3, ...) like the suggestions of a 409, every rename is
+	logged. The old non-unique index is then dropped so the unique one can take its name.
+	Once the unique index exists this only lists the indexes.
+*/
+func prepareUniqueNameIndex(ctx context.Context) error {
+	unique, exists, err := nameIndexState(ctx)
+	if err != nil || unique {
+		return err
+	}
+
+	if err := renameDuplicateNames(ctx); err != nil {
+		return err
+	}
+	if exists {
+		if _, err := db.Collection(collectionName).Indexes().DropOne(ctx, nameIndexName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nameIndexState reports whether snippetname_1 exists and whether it is unique
+func nameIndexState(ctx context.Context) (unique, exists bool, err error) {
+	cursor, err := db.Collection(collectionName).Indexes().List(ctx)
+	if isCommandError(err, namespaceNotFoundCode) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	var indexes []struct {
+		Name   string `bson:"name"`
+		Unique bool   `bson:"unique"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return false, false, err
+	}
+	for _, index := range indexes {
+		if index.Name == nameIndexName {
+			return index.Unique, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// renameDuplicateNames gives every snippet sharing its name with an older one a free numbered name
+func renameDuplicateNames(ctx context.Context) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$snippetname", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := db.Collection(collectionName).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Name string               `bson:"_id"`
+		IDs  []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		n := 2
+		// the oldest keeps the name
+		for _, id := range group.IDs[1:] {
+			renamed := false
+			for ; n < 2+maxDedupeProbes && !renamed; n++ {
+				candidate, ok := numberedName(group.Name, n)
+				if !ok {
+					break
+				}
+				taken, err := db.Collection(collectionName).CountDocuments(ctx, bson.M{"snippetname": candidate}, options.Count().SetLimit(1))
+				if err != nil {
+					return err
+				}
+				if taken > 0 {
+					continue
+				}
+				if _, err := db.Collection(collectionName).UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"snippetname": candidate}}); err != nil {
+					return err
+				}
+				log.Printf("renamed snippet %s from %q to %q, the name is unique now\n", id.Hex(), group.Name, candidate)
+				renamed = true
+			}
+			if !renamed {
+				return fmt.Errorf("no free name found for snippet %s named %q", id.Hex(), group.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestNumberedName(t *testing.T) {
+	oldMax := cfg.MaxNameLen
+	cfg.MaxNameLen = 8
+	t.Cleanup(func() { cfg.MaxNameLen = oldMax })
+
+	tests := []struct {
+		name   string
+		n      int
+		want   string
+		wantOK bool
+	}{
+		{"abc", 2, "abc-2", true},
+		{"abcdefgh", 2, "abcdef-2", true},
+		{"abcdefgh", 10, "abcde-10", true},
+		{"äöüäöüäö", 2, "äöüäöü-2", true},
+		{"abc", 1234567, "a-1234567", false},
+	}
+	for _, tt := range tests {
+		got, ok := numberedName(tt.name, tt.n)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("numberedName(%q, %d) = %q, %v, want %q, %v", tt.name, tt.n, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// TestUniqueNameMigration starts from a deployment that has the old non-unique name index and duplicates
+func TestUniqueNameMigration(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	coll := db.Collection(collectionName)
+
+	if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "snippetname", Value: 1}},
+		Options: options.Index().SetName(nameIndexName),
+	}); err != nil {
+		t.Fatalf("failed to create the old index: %s", err)
+	}
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	docs := []interface{}{
+		bson.M{"_id": ids[0], "snippetname": "hello", "code": "a"},
+		bson.M{"_id": ids[1], "snippetname": "hello", "code": "b"},
+		bson.M{"_id": ids[2], "snippetname": "hello", "code": "c"},
+		bson.M{"_id": primitive.NewObjectID(), "snippetname": "hello-2", "code": "d"},
+	}
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		t.Fatalf("failed to insert the duplicates: %s", err)
+	}
+
+	ensureCollections()
+	if failed := ensureIndexes(); failed != 0 {
+		t.Fatalf("%d indexes failed", failed)
+	}
+	if unique, exists, err := nameIndexState(ctx); err != nil || !exists || !unique {
+		t.Fatalf("nameIndexState = %v, %v, %v, want a unique index", unique, exists, err)
+	}
+
+	// the oldest keeps the name, hello-2 is taken so the others get hello-3 and hello-4
+	want := map[primitive.ObjectID]string{ids[0]: "hello", ids[1]: "hello-3", ids[2]: "hello-4"}
+	for id, name := range want {
+		var got struct {
+			Name string `bson:"snippetname"`
+		}
+		if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&got); err != nil {
+			t.Fatalf("failed to find %s: %s", id.Hex(), err)
+		}
+		if got.Name != name {
+			t.Errorf("snippet %s is named %q, want %q", id.Hex(), got.Name, name)
+		}
+	}
+
+	_, err := coll.InsertOne(ctx, bson.M{"snippetname": "hello", "code": "e"})
+	if !isDuplicateKey(err) || !strings.Contains(err.Error(), nameIndexName) {
+		t.Errorf("inserting a taken name = %v, want a duplicate key error on %s", err, nameIndexName)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+)
+
+// uploadFormOverhead is the room left in the request body for the multipart boundaries and other form fields
+const uploadFormOverhead = 64 * 1024
+
+/*
+uploadSnippet creates a snippet from an uploaded file (multipart form, field "file").
+
+	The file content becomes the code, the file name without its extension becomes the
+	snippet name (unless a "snippet_name" form field is sent) and the extension decides the
+	language. The whole body is capped with MaxBytesReader so a huge upload is cut off while it
+	is read, and files that are not valid UTF-8 text (i.e. binaries) are rejected.
+*/
+func uploadSnippet(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.MaxCodeBytes)+uploadFormOverhead)
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+				fmt.Sprintf("the file must not exceed %d bytes", cfg.MaxCodeBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, `the request must be a multipart form with a "file" field`)
+		return
+	}
+	defer file.Close()
+
+	// read one byte more than allowed to tell "exactly at the limit" apart from "too big"
+	content, err := io.ReadAll(io.LimitReader(file, int64(cfg.MaxCodeBytes)+1))
+	if err != nil {
+		log.Printf("failed to read uploaded file: %s\n", err)
+		writeError(w, http.StatusBadRequest, errCodeInvalidBody, "failed to read the uploaded file")
+		return
+	}
+	if len(content) > cfg.MaxCodeBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeCodeTooLarge,
+			fmt.Sprintf("the file must not exceed %d bytes", cfg.MaxCodeBytes))
+		return
+	}
+	if !utf8.Valid(content) {
+		writeError(w, http.StatusBadRequest, errCodeBinaryFile, "the file is not valid UTF-8 text")
+		return
+	}
+
+	filename := filepath.Base(header.Filename)
+	c := CodeSnippet{
+		SnippetName: strings.TrimSuffix(filename, filepath.Ext(filename)),
+		Code:        string(content),
+		Language:    languageForFilename(filename),
+	}
+	var ok bool
+	if c.Source, ok = requestSource(w, r); !ok {
+		return
+	}
+	if name := strings.TrimSpace(r.FormValue("snippet_name")); name != "" {
+		c.SnippetName = name
+	}
+
+	if errs := validateSnippet(c); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
+	if m := moderateSnippet(c.SnippetName, c.Code); m != nil {
+		writeModerationRejected(w, m)
+		return
+	}
+
+	cm := newSnippetModel(c)
+	if !takeOwnership(w, r, &cm) {
+		return
+	}
+	err = insertNewSnippet(r.Context(), &cm, func() error {
+		_, err := db.Collection(collectionName).InsertOne(r.Context(), &cm)
+		return err
+	})
+	if isDuplicateKey(err) {
+		writeError(w, http.StatusConflict, errCodeDuplicateName, fmt.Sprintf("a snippet named %q already exists", cm.SnippetName))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save uploaded snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save Code Snippet")
+		return
+	}
+
+	rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "Snippet created successfully",
+		"data":    snippetResponse(r, cm),
+	})
+}
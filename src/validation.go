@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// validateSnippet checks a snippet received from the client before it is written to the database.
+// It returns every problem found (not just the first) so a client can highlight all the bad fields at once.
+// The limits come from cfg so operators can tune them per deployment.
+func validateSnippet(c CodeSnippet) []fieldError {
+	var errs []fieldError
+
+	nameLen := utf8.RuneCountInString(c.SnippetName)
+	switch {
+	case c.SnippetName == "":
+		errs = append(errs, fieldError{
+			Field:   "snippet_name",
+			Code:    errCodeSnippetNameRequired,
+			Message: "the snippet Name field is requested",
+		})
+	case nameLen < cfg.MinNameLen:
+		errs = append(errs, fieldError{
+			Field:   "snippet_name",
+			Code:    errCodeSnippetNameTooShort,
+			Message: fmt.Sprintf("the snippet name must be at least %d characters", cfg.MinNameLen),
+			Limit:   cfg.MinNameLen,
+		})
+	case nameLen > cfg.MaxNameLen:
+		errs = append(errs, fieldError{
+			Field:   "snippet_name",
+			Code:    errCodeSnippetNameTooLong,
+			Message: fmt.Sprintf("the snippet name must not exceed %d characters", cfg.MaxNameLen),
+			Limit:   cfg.MaxNameLen,
+		})
+	}
+
+	if c.Code == "" {
+		errs = append(errs, fieldError{
+			Field:   "code",
+			Code:    errCodeCodeRequired,
+			Message: "the code input field is requested",
+		})
+	} else if len(c.Code) > cfg.MaxCodeBytes {
+		errs = append(errs, fieldError{
+			Field:   "code",
+			Code:    errCodeCodeTooLarge,
+			Message: fmt.Sprintf("the code must not exceed %d bytes", cfg.MaxCodeBytes),
+			Limit:   cfg.MaxCodeBytes,
+		})
+	}
+
+	if len(cfg.AllowedLanguages) > 0 && c.Language != "" && !languageAllowed(c.Language) {
+		errs = append(errs, fieldError{
+			Field:   "language",
+			Code:    errCodeLanguageNotAllowed,
+			Message: fmt.Sprintf("the language must be one of: %s", strings.Join(cfg.AllowedLanguages, ", ")),
+		})
+	}
+
+	for _, tag := range c.Tags {
+		if _, ok := validTag(tag); !ok {
+			errs = append(errs, fieldError{
+				Field:   "tags",
+				Code:    errCodeInvalidTag,
+				Message: fmt.Sprintf("a tag must be between 1 and %d characters", maxTagLen),
+				Limit:   maxTagLen,
+			})
+			break
+		}
+	}
+
+	if len(c.Tags) > cfg.MaxTags {
+		errs = append(errs, fieldError{
+			Field:   "tags",
+			Code:    errCodeTooManyTags,
+			Message: fmt.Sprintf("a snippet can have at most %d tags", cfg.MaxTags),
+			Limit:   cfg.MaxTags,
+		})
+	}
+
+	if _, ok := validFolder(c.Folder); !ok {
+		errs = append(errs, fieldError{
+			Field:   "folder",
+			Code:    errCodeInvalidFolder,
+			Message: fmt.Sprintf("a folder must be a path of non-empty segments of at most %d characters", maxFolderLen),
+			Limit:   maxFolderLen,
+		})
+	}
+
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(time.Now()) {
+		errs = append(errs, fieldError{
+			Field:   "expires_at",
+			Code:    errCodeInvalidExpiry,
+			Message: "the expiry time must be in the future",
+		})
+	}
+
+	if c.MaxViews < 0 {
+		errs = append(errs, fieldError{
+			Field:   "max_views",
+			Code:    errCodeInvalidMaxViews,
+			Message: "max_views must not be negative, 0 means no limit",
+		})
+	}
+
+	return errs
+}
+
+// languageAllowed reports whether the language is in ALLOWED_LANGUAGES
+func languageAllowed(language string) bool {
+	language = normalizeLanguage(language)
+	for _, allowed := range cfg.AllowedLanguages {
+		if allowed == language {
+			return true
+		}
+	}
+	return false
+}
+
+// trimCode removes trailing spaces and tabs from every line and drops one trailing newline.
+// Line endings themselves are kept, a CRLF file stays CRLF.
+func trimCode(code string) string {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		cr := strings.HasSuffix(line, "\r")
+		line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+		if cr {
+			line += "\r"
+		}
+		lines[i] = line
+	}
+
+	trimmed := strings.Join(lines, "\n")
+	if strings.HasSuffix(trimmed, "\r\n") {
+		return strings.TrimSuffix(trimmed, "\r\n")
+	}
+	return strings.TrimSuffix(trimmed, "\n")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+viewCounter buffers the view counts of snippet reads and writes them in batches.
+
+	A read only increments a counter in memory, flush writes every snippet's count at once with
+	one unordered bulk of $inc on viewCount. runViewFlusher flushes every VIEW_FLUSH_INTERVAL or
+	as soon as VIEW_FLUSH_COUNT views are pending, main flushes one last time after the server
+	has drained on shutdown. Counts a flush couldn't write are put back and retried with the
+	next one, so they are only lost when the process dies without a graceful shutdown.
+	view_count in responses is what was flushed so far, the pending views aren't in it yet.
+	In read-only mode views aren't counted and nothing is flushed, counts pending from before
+	the switch wait in the buffer until read-only is turned off again.
+*/
+type viewCounter struct {
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]int
+	total   int
+	// full wakes runViewFlusher once total reaches VIEW_FLUSH_COUNT
+	full chan struct{}
+}
+
+var viewCounts = &viewCounter{pending: map[primitive.ObjectID]int{}, full: make(chan struct{}, 1)}
+
+// add counts one view of the snippet, unless the service is read-only
+func (c *viewCounter) add(id primitive.ObjectID) {
+	if readOnly.Load() {
+		return
+	}
+	c.mu.Lock()
+	c.pending[id]++
+	c.total++
+	full := c.total >= cfg.ViewFlushCount
+	c.mu.Unlock()
+
+	if full {
+		select {
+		case c.full <- struct{}{}:
+		default:
+			// a flush is already due
+		}
+	}
+}
+
+// takePending empties the buffer and returns what was in it
+func (c *viewCounter) takePending() map[primitive.ObjectID]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.pending = map[primitive.ObjectID]int{}
+	c.total = 0
+	return pending
+}
+
+// putBack returns counts that couldn't be written to the buffer
+func (c *viewCounter) putBack(counts map[primitive.ObjectID]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, n := range counts {
+		c.pending[id] += n
+		c.total += n
+	}
+}
+
+/*
+flush writes the pending views, one UpdateOne $inc per snippet in a single unordered BulkWrite.
+
+	When the bulk reports individual write errors only those snippets' counts are put back,
+	the other increments were applied. Any other error (e.g. the database is unreachable) puts
+	everything back, the bulk can then only have been applied partly when it was cut off
+	after reaching the server, which would count those views twice rather than lose them.
+*/
+func (c *viewCounter) flush(ctx context.Context) error {
+	if readOnly.Load() {
+		return nil
+	}
+	pending := c.takePending()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(pending))
+	models := make([]mongo.WriteModel, 0, len(pending))
+	for id, n := range pending {
+		ids = append(ids, id)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$inc": bson.M{"viewCount": n}}))
+	}
+
+	_, err := db.Collection(collectionName).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		failed := map[primitive.ObjectID]int{}
+		for _, e := range bulkErr.WriteErrors {
+			failed[ids[e.Index]] = pending[ids[e.Index]]
+		}
+		c.putBack(failed)
+		return err
+	}
+	c.putBack(pending)
+	return err
+}
+
+// runViewFlusher flushes the view counts every interval and whenever the buffer is full, until
+// ctx is done. It flushes once more before returning, main flushes again after the drain.
+func runViewFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushViewCounts()
+			return
+		case <-ticker.C:
+		case <-viewCounts.full:
+		}
+		flushViewCounts()
+	}
+}
+
+// flushViewCounts flushes with its own timeout, a failure is logged and retried with the next flush
+func flushViewCounts() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := viewCounts.flush(ctx); err != nil {
+		log.Printf("failed to flush view counts: %s\n", err)
+	}
+}
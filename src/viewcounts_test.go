@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestViewCounterReadOnly checks that nothing is counted or written while the service is read-only
+func TestViewCounterReadOnly(t *testing.T) {
+	c := &viewCounter{pending: map[primitive.ObjectID]int{}, full: make(chan struct{}, 1)}
+	id := primitive.NewObjectID()
+	c.add(id)
+
+	old := readOnly.Load()
+	readOnly.Store(true)
+	t.Cleanup(func() { readOnly.Store(old) })
+
+	c.add(id)
+	// db is nil in unit tests, a flush reaching the database would panic
+	if err := c.flush(context.Background()); err != nil {
+		t.Fatalf("flush while read-only = %s, want nil", err)
+	}
+	if got := c.pending[id]; got != 1 {
+		t.Errorf("pending views = %d, want 1 (the view from before read-only, kept for later)", got)
+	}
+}
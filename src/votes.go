@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// votesCollectionName has one document per snippet and user, its unique index is what prevents double voting
+	votesCollectionName = "code-snippet-votes"
+)
+
+// voteModel is the vote of one user on one snippet
+type voteModel struct {
+	SnippetID primitive.ObjectID `bson:"snippetId"`
+	UserID    string             `bson:"userId"`
+	Value     int                `bson:"value"`
+	VotedAt   time.Time          `bson:"votedAt"`
+}
+
+/*
+voteSnippet records an up (+1) or down (-1) vote, body: {"vote": 1}, and returns the new score.
+
+	Every user (X-User-ID) has at most one vote per snippet. The vote is upserted into the votes
+	collection and the previous value comes back in the same step, the score then moves by the
+	difference with $inc: voting the same way twice changes nothing, switching from +1 to -1
+	moves it by -2. Voting is allowed on locked snippets, the lock only protects the content.
+*/
+func voteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	userID := strings.TrimSpace(r.Header.Get(userIDHeader))
+	if userID == "" {
+		writeError(w, http.StatusBadRequest, errCodeUserIDRequired, "the X-User-ID header is required to vote")
+		return
+	}
+
+	var body struct {
+		Vote int `json:"vote"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Vote != 1 && body.Vote != -1) {
+		writeDecodeError(w, r, err, `the request body must be {"vote": 1} or {"vote": -1}`)
+		return
+	}
+
+	if !snippetExists(w, r, id) {
+		return
+	}
+
+	filter := bson.M{"snippetId": id, "userId": userID}
+	update := bson.M{"$set": bson.M{"value": body.Vote, "votedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var previous voteModel
+	err := db.Collection(votesCollectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&previous)
+	if isDuplicateKey(err) {
+		// the user's first vote raced another one, the unique index let only one insert, which now exists
+		err = db.Collection(votesCollectionName).FindOneAndUpdate(r.Context(), filter, update, opts).Decode(&previous)
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("failed to save vote: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save vote")
+		return
+	}
+	// ErrNoDocuments means the vote was just inserted, previous.Value is then 0
+	delta := body.Vote - previous.Value
+
+	scoreOpts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"score": 1})
+	var updated CodeSnippetModel
+	err = db.Collection(collectionName).FindOneAndUpdate(r.Context(), withShardKey(r, bson.M{"_id": id}), bson.M{"$inc": bson.M{"score": delta}}, scoreOpts).Decode(&updated)
+	snippetCache.invalidate(id)
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Snippet not found")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update score: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "Failed to save vote")
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"snippet_id": id.Hex(),
+		"vote":       body.Vote,
+		"score":      updated.Score,
+	})
+}
+
+// getTopSnippets lists snippets by score, highest first, paginated like getAllSnippets.
+// Snippets nobody voted on have no score field and come after every voted one.
+// Archived snippets are left out, see withoutArchived.
+func getTopSnippets(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, err.Error())
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "score", Value: -1}, {Key: "createAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(page.Offset).
+		SetLimit(page.Limit)
+	cursor, err := db.Collection(collectionName).Find(r.Context(), withoutArchived(r, bson.M{}), opts)
+	if err != nil {
+		log.Printf("failed to fetch top snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+	found := []CodeSnippetModel{}
+	if err := cursor.All(r.Context(), &found); err != nil {
+		log.Printf("failed to fetch top snippets: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to fetch snippets")
+		return
+	}
+
+	snippets := []CodeSnippet{}
+	for _, s := range found {
+		snippets = append(snippets, snippetResponse(r, s))
+	}
+
+	respond(w, r, http.StatusOK, renderer.M{
+		"data":       snippets,
+		"pagination": page,
+	})
+}
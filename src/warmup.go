@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+/*
+warmupPool opens n connections to Mongo before the server starts accepting requests.
+
+	The driver connects lazily, so right after a deploy the first requests each pay for a new
+	connection (TCP, TLS, auth). Here n goroutines ping and run a tiny query at the same time,
+	which makes the pool open n connections that stay idle afterwards for real traffic to reuse.
+	A failure is only logged, the server still starts and the pool fills on demand as before.
+*/
+func warmupPool(n int) {
+	if n <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Ping(ctx, readpref.Primary())
+			if err == nil {
+				opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+				err = db.Collection(collectionName).FindOne(ctx, bson.M{}, opts).Err()
+				if err == mongo.ErrNoDocuments {
+					err = nil
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				log.Printf("connection pool warmup failed: %s\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("connection pool warmup: %d of %d connections ready in %s\n", n-failed, n, time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// watchWriteTimeout is how long pushing one event may take before the watcher is dropped
+	watchWriteTimeout = 10 * time.Second
+	// changeStreamNotSupportedCode is returned by $changeStream on a standalone server
+	changeStreamNotSupportedCode = 40573
+)
+
+// watchers is the number of open WebSocket watchers, capped by MAX_WATCHERS
+var watchers atomic.Int64
+
+// watchersDone is closed on shutdown, every watcher then closes its WebSocket. http.Server.Shutdown
+// doesn't wait for hijacked connections, so they are stopped through srv.RegisterOnShutdown.
+var (
+	watchersDone     = make(chan struct{})
+	stopWatchersOnce sync.Once
+)
+
+// stopWatchers ends every open watcher, it is safe to call more than once
+func stopWatchers() {
+	stopWatchersOnce.Do(func() { close(watchersDone) })
+}
+
+// isWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// watchEvent is one message pushed to a watcher. ResumeToken is the change stream position
+// after this event, a client that reconnects with ?resume_after= gets the changes it missed.
+type watchEvent struct {
+	Type        string       `json:"type"`
+	ID          string       `json:"id"`
+	ResumeToken string       `json:"resume_token,omitempty"`
+	Data        *CodeSnippet `json:"data,omitempty"`
+	Message     string       `json:"message,omitempty"`
+}
+
+// snippetChange decodes the change stream events watchSnippet asks for
+type snippetChange struct {
+	OperationType string            `bson:"operationType"`
+	FullDocument  *CodeSnippetModel `bson:"fullDocument"`
+}
+
+/*
+watchSnippet pushes the changes of one snippet over a WebSocket (GET /id/{codeid}/ws).
+
+	Every connection opens its own Mongo change stream filtered on the snippet's _id, so any
+	number of clients can watch the same snippet and each gets every event. The change stream
+	is opened before the upgrade, so a missing snippet (404), a server without change streams
+	(501, they need a replica set) or an invalid ?resume_after= (400) are plain HTTP errors.
+
+	Messages are JSON: {"type": "updated", "data": <snippet>} after every change (the snippet as
+	it is after the change) and {"type": "deleted"} once, after which the server closes the
+	socket. Every event carries a resume_token, a client that lost the connection reconnects
+	with ?resume_after=<the last token> and first receives what changed in between (as long as
+	the oplog still has it). The driver resumes the stream itself after transient errors, any
+	other error ends the watch with {"type": "error"}. Messages from the client are ignored,
+	reading them is only how a disconnect is noticed.
+
+	Watchers don't count against MAX_CONCURRENT (see concurrencyLimiter), they are capped by
+	MAX_WATCHERS instead, 0 disables the endpoint.
+*/
+func watchSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := snippetIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !isWebSocketUpgrade(r) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "this endpoint only speaks WebSocket, send an Upgrade: websocket request")
+		return
+	}
+	if watchers.Add(1) > int64(cfg.MaxWatchers) {
+		watchers.Add(-1)
+		w.Header().Set("Retry-After", concurrencyRetryAfter)
+		writeError(w, http.StatusTooManyRequests, errCodeTooManyRequests, "too many open watchers, please retry later")
+		return
+	}
+	defer watchers.Add(-1)
+
+	if !snippetExists(w, r, id) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	resumeAfter := strings.TrimSpace(r.URL.Query().Get("resume_after"))
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"documentKey._id": id,
+		"operationType":   bson.M{"$in": bson.A{"update", "replace", "delete"}},
+	}}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeAfter != "" {
+		opts.SetResumeAfter(bson.M{"_data": resumeAfter})
+	}
+	stream, err := db.Collection(collectionName).Watch(ctx, pipeline, opts)
+	switch {
+	case isCommandError(err, changeStreamNotSupportedCode):
+		writeError(w, http.StatusNotImplemented, errCodeNotImplemented, "watching needs MongoDB change streams, which are only available on a replica set")
+		return
+	case err != nil && resumeAfter != "":
+		writeError(w, http.StatusBadRequest, errCodeInvalidQuery, "resume_after is not a valid resume token or is too old")
+		return
+	case err != nil:
+		log.Printf("failed to watch snippet: %s\n", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to watch snippet")
+		return
+	}
+	defer stream.Close(context.Background())
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		// the server's read and write timeouts are still set on the hijacked connection
+		ws.SetDeadline(time.Time{})
+
+		go func() {
+			// returns once the client closes the socket (or the close below ends it)
+			io.Copy(io.Discard, ws)
+			cancel()
+		}()
+		go func() {
+			select {
+			case <-watchersDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		for stream.Next(ctx) {
+			var change snippetChange
+			if err := stream.Decode(&change); err != nil {
+				log.Printf("failed to decode snippet change: %s\n", err)
+				continue
+			}
+			event := watchEvent{Type: "updated", ID: id.Hex(), ResumeToken: resumeTokenData(stream.ResumeToken())}
+			switch {
+			case change.OperationType == "delete":
+				event.Type = "deleted"
+			case change.FullDocument == nil:
+				// deleted again before the update lookup ran, the delete event follows
+				continue
+			default:
+				data := snippetResponse(r, *change.FullDocument)
+				event.Data = &data
+			}
+			if !sendWatchEvent(ws, event) || event.Type == "deleted" {
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("snippet watch ended: %s\n", err)
+			sendWatchEvent(ws, watchEvent{Type: "error", ID: id.Hex(), Message: "the watch ended, reconnect with the last resume_token"})
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// sendWatchEvent writes one event, false when the client can't be reached anymore
+func sendWatchEvent(ws *websocket.Conn, event watchEvent) bool {
+	ws.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+	if err := websocket.JSON.Send(ws, event); err != nil {
+		return false
+	}
+	return true
+}
+
+// resumeTokenData is the opaque _data string of a resume token, what ?resume_after= takes back
+func resumeTokenData(token bson.Raw) string {
+	data, _ := token.Lookup("_data").StringValueOK()
+	return data
+}